@@ -0,0 +1,225 @@
+// Package compose runs docker compose projects through the Docker Engine API,
+// without the docker compose CLI.
+//
+// Load a compose file into a Project and drive it with Up, Down, Restart, Ps,
+// Logs and Exec:
+//
+//	project, err := compose.Load(ctx, compose.LoadOptions{Path: "docker-compose.yml"})
+//	if err != nil {
+//		return err
+//	}
+//	if err := project.Up(ctx, compose.UpOptions{}); err != nil {
+//		return err
+//	}
+//	defer project.Down(ctx, compose.DownOptions{RemoveNetworks: true})
+//
+// # Compatibility
+//
+// This package is the public API of the module and follows semantic
+// versioning. Within a major version exported identifiers are not removed or
+// renamed and function signatures don't change. New fields may be added to the
+// option and result structs, so build them with field names rather than
+// positionally. The zero value of a new option field keeps the previous
+// behaviour.
+//
+// Everything under internal/ may change at any time. The compose-go types
+// returned by Project.Config are those of the compose-go version in go.mod and
+// change with it.
+package compose
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/JamesTiberiusKirk/go-docker-compose/internal/composeconvert"
+	"github.com/JamesTiberiusKirk/go-docker-compose/internal/runner"
+	"github.com/compose-spec/compose-go/types"
+	"github.com/docker/docker/client"
+)
+
+// Project is a loaded compose project bound to a Docker client.
+type Project struct {
+	cli     Engine
+	project *types.Project
+}
+
+// Load reads a compose file and returns a handle to its project. Service
+// names get LoadOptions.NamePrefix and NameSuffix applied, the other methods
+// accept both those and the names as written in the compose file.
+func Load(ctx context.Context, opts LoadOptions) (*Project, error) {
+	cli := opts.Client
+	if cli == nil {
+		dockerClient, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			return nil, fmt.Errorf("create docker client: %w", err)
+		}
+		cli = dockerClient
+	}
+
+	project, err := composeconvert.LoadComposeStack(ctx, composeconvert.LoadComposeProjectOptions{
+		DockerComposePath: opts.Path,
+		NamePrefix:        opts.NamePrefix,
+		NameSuffix:        opts.NameSuffix,
+		Env:               opts.Env,
+		PullEnvFromSystem: opts.PullEnvFromSystem,
+		WorkingDir:        opts.WorkingDir,
+		ProjectName:       opts.ProjectName,
+		Profiles:          opts.Profiles,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Project{cli: cli, project: project}, nil
+}
+
+// Name returns the project name stamped on every resource of the project.
+func (p *Project) Name() string {
+	return p.project.Name
+}
+
+// Services returns the names of the services in the order they're started.
+func (p *Project) Services() []string {
+	names := make([]string, 0, len(p.project.Services))
+	for _, service := range p.project.Services {
+		names = append(names, service.Name)
+	}
+	return names
+}
+
+// Config returns the underlying compose-go project. Changes made to it are
+// picked up by the next call to Up.
+func (p *Project) Config() *types.Project {
+	return p.project
+}
+
+// Up creates and starts the project's containers, converging with any left
+// from a previous run.
+func (p *Project) Up(ctx context.Context, opts UpOptions) error {
+	var timeouts map[string]time.Duration
+	if len(opts.ServiceWaitTimeouts) > 0 {
+		timeouts = make(map[string]time.Duration, len(opts.ServiceWaitTimeouts))
+		for service, timeout := range opts.ServiceWaitTimeouts {
+			name, err := p.serviceName(service)
+			if err != nil {
+				return err
+			}
+			timeouts[name] = timeout
+		}
+	}
+	return convertError(runner.Up(ctx, p.cli, p.project, runner.UpOptions{
+		ForceRecreate:       opts.ForceRecreate,
+		NoRecreate:          opts.NoRecreate,
+		PullPolicy:          opts.PullPolicy,
+		MaxParallelism:      opts.MaxParallelism,
+		DockerConfigDir:     opts.DockerConfigDir,
+		Events:              runnerSink(opts.Events),
+		WaitTimeout:         opts.WaitTimeout,
+		ServiceWaitTimeouts: timeouts,
+		DiagnosticLogLines:  opts.DiagnosticLogLines,
+		Rollback:            opts.Rollback,
+	}))
+}
+
+// Down stops and removes the project's containers and whatever else opts asks
+// for.
+func (p *Project) Down(ctx context.Context, opts DownOptions) error {
+	return runner.Down(ctx, p.cli, p.project, runner.DownOptions{
+		Timeout:        opts.Timeout,
+		RemoveImages:   opts.RemoveImages,
+		RemoveVolumes:  opts.RemoveVolumes,
+		RemoveNetworks: opts.RemoveNetworks,
+		RemoveOrphans:  opts.RemoveOrphans,
+		Events:         runnerSink(opts.Events),
+	})
+}
+
+// Restart restarts the named services, or all of them when none are given.
+// Services depending on a restarted one with `restart: true` are restarted
+// after it.
+func (p *Project) Restart(ctx context.Context, services []string, opts RestartOptions) error {
+	names := make([]string, 0, len(services))
+	for _, service := range services {
+		name, err := p.serviceName(service)
+		if err != nil {
+			return err
+		}
+		names = append(names, name)
+	}
+	return convertError(runner.Restart(ctx, p.cli, p.project, names, runner.RestartOptions{
+		Timeout:     opts.Timeout,
+		WaitTimeout: opts.WaitTimeout,
+		Events:      runnerSink(opts.Events),
+	}))
+}
+
+// Ps lists the project's containers, running or not.
+func (p *Project) Ps(ctx context.Context) ([]Container, error) {
+	list, err := runner.Ps(ctx, p.cli, p.project)
+	if err != nil {
+		return nil, err
+	}
+
+	containers := make([]Container, 0, len(list))
+	for _, c := range list {
+		name := c.ID
+		if len(c.Names) > 0 {
+			name = c.Names[0][1:]
+		}
+		containers = append(containers, Container{
+			ID:      c.ID,
+			Name:    name,
+			Service: c.Labels[runner.ServiceLabel],
+			Image:   c.Image,
+			State:   string(c.State),
+			Status:  c.Status,
+			Labels:  c.Labels,
+		})
+	}
+	return containers, nil
+}
+
+// Logs writes the output of the service's container to stdout and stderr.
+func (p *Project) Logs(ctx context.Context, service string, stdout, stderr io.Writer, opts LogsOptions) error {
+	name, err := p.serviceName(service)
+	if err != nil {
+		return err
+	}
+	return runner.Logs(ctx, p.cli, p.project, name, stdout, stderr, runner.LogsOptions{
+		Follow:     opts.Follow,
+		Tail:       opts.Tail,
+		Since:      opts.Since,
+		Timestamps: opts.Timestamps,
+	})
+}
+
+// Exec runs cmd in the service's running container and returns its exit code.
+// A non-zero exit code is not an error.
+func (p *Project) Exec(ctx context.Context, service string, cmd []string, opts ExecOptions) (int, error) {
+	name, err := p.serviceName(service)
+	if err != nil {
+		return 0, err
+	}
+	return runner.Exec(ctx, p.cli, p.project, name, cmd, runner.ExecOptions{
+		Env:        opts.Env,
+		User:       opts.User,
+		WorkingDir: opts.WorkingDir,
+		Privileged: opts.Privileged,
+		Tty:        opts.Tty,
+		Stdin:      opts.Stdin,
+		Stdout:     opts.Stdout,
+		Stderr:     opts.Stderr,
+	})
+}
+
+// serviceName maps a service name, as in the compose file or with the prefix
+// and suffix applied, to the name the project uses.
+func (p *Project) serviceName(name string) (string, error) {
+	for _, service := range p.project.Services {
+		if service.Name == name || composeconvert.OriginalServiceName(service) == name {
+			return service.Name, nil
+		}
+	}
+	return "", fmt.Errorf("no service %s in project %s", name, p.project.Name)
+}
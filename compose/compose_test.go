@@ -0,0 +1,141 @@
+package compose
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/JamesTiberiusKirk/go-docker-compose/internal/fakeengine"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// load writes the compose file to a temp dir and loads it against e as
+// project "unit", with the service names prefixed.
+func load(t *testing.T, e *fakeengine.Engine, composeYML string) *Project {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "docker-compose.yml")
+	require.NoError(t, os.WriteFile(path, []byte(composeYML), 0644))
+	project, err := Load(t.Context(), LoadOptions{Path: path, Client: e, ProjectName: "unit", NamePrefix: "t-"})
+	require.NoError(t, err)
+	return project
+}
+
+func TestProject_Lifecycle(t *testing.T) {
+	e := fakeengine.New()
+	e.SetBehavior("t-web", fakeengine.Behavior{Logs: "listening on :80\n"})
+	project := load(t, e, `
+services:
+  web:
+    image: nginx:alpine
+    depends_on: [db]
+  db:
+    image: postgres:16
+`)
+	assert.Equal(t, "unit", project.Name())
+	assert.Equal(t, []string{"t-db", "t-web"}, project.Services())
+
+	var mu sync.Mutex
+	var got []Event
+	sink := EventSinkFunc(func(e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, e)
+	})
+	require.NoError(t, project.Up(t.Context(), UpOptions{Events: sink}))
+
+	started := map[string]bool{}
+	for _, e := range got {
+		if e.Type == EventContainerStarted {
+			started[e.Service] = true
+		}
+	}
+	assert.Equal(t, map[string]bool{"t-db": true, "t-web": true}, started)
+
+	list, err := project.Ps(t.Context())
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	assert.Equal(t, "t-db", list[0].Name)
+	assert.Equal(t, "t-db", list[0].Service)
+	assert.Equal(t, "running", list[0].State)
+
+	// Services are found by the name in the compose file too.
+	var logs bytes.Buffer
+	require.NoError(t, project.Logs(t.Context(), "web", &logs, &logs, LogsOptions{}))
+	assert.Equal(t, "listening on :80\n", logs.String())
+	require.NoError(t, project.Restart(t.Context(), []string{"db"}, RestartOptions{Events: sink}))
+	err = project.Restart(t.Context(), []string{"cache"}, RestartOptions{Events: sink})
+	require.EqualError(t, err, "no service cache in project unit")
+
+	require.NoError(t, project.Down(t.Context(), DownOptions{Events: sink}))
+	list, err = project.Ps(t.Context())
+	require.NoError(t, err)
+	assert.Empty(t, list)
+}
+
+func TestProject_DependencyFailedError(t *testing.T) {
+	e := fakeengine.New()
+	e.SetBehavior("t-migrate", fakeengine.Behavior{Exit: true, ExitCode: 3})
+	project := load(t, e, `
+services:
+  app:
+    image: alpine:latest
+    depends_on:
+      migrate:
+        condition: service_completed_successfully
+  migrate:
+    image: alpine:latest
+`)
+
+	var eventErr error
+	sink := EventSinkFunc(func(e Event) {
+		if e.Type == EventError && e.Service == "t-app" {
+			eventErr = e.Err
+		}
+	})
+	err := project.Up(t.Context(), UpOptions{Events: sink})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "t-migrate exited with code 3")
+
+	for _, err := range []error{err, eventErr} {
+		var failed *DependencyFailedError
+		require.True(t, errors.As(err, &failed), "%v", err)
+		assert.Equal(t, DependencyFailedError{
+			Service:    "t-app",
+			Dependency: "t-migrate",
+			Condition:  "service_completed_successfully",
+			Reason:     DependencyExited,
+			ExitCode:   3,
+		}, *failed)
+	}
+}
+
+func TestSinks(t *testing.T) {
+	e := fakeengine.New()
+	project := load(t, e, `
+services:
+  web:
+    image: nginx:alpine
+`)
+
+	var console, jsonOut bytes.Buffer
+	sink := MultiSink(NewConsoleSink(&console, false), NewJSONSink(&jsonOut))
+	require.NoError(t, project.Up(t.Context(), UpOptions{Events: sink}))
+
+	assert.Contains(t, console.String(), "Started container t-web")
+	var types []EventType
+	scanner := bufio.NewScanner(&jsonOut)
+	for scanner.Scan() {
+		var evt struct {
+			Type EventType `json:"type"`
+		}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &evt), scanner.Text())
+		types = append(types, evt.Type)
+	}
+	assert.Contains(t, types, EventContainerStarted)
+}
@@ -0,0 +1,86 @@
+package compose
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/JamesTiberiusKirk/go-docker-compose/internal/runner"
+)
+
+// DependencyFailure says why a dependency failed.
+type DependencyFailure string
+
+const (
+	// DependencyExited means the container stopped, for
+	// service_completed_successfully with a non-zero exit code.
+	DependencyExited DependencyFailure = "exited"
+	// DependencyOOMKilled means the container was killed for running out of
+	// memory.
+	DependencyOOMKilled DependencyFailure = "oom_killed"
+	// DependencyUnhealthy means the container's healthcheck reported it
+	// unhealthy.
+	DependencyUnhealthy DependencyFailure = "unhealthy"
+)
+
+// DependencyFailedError is returned by Up and Restart when a dependency can no
+// longer meet its depends_on condition: its container exited, was OOM-killed
+// or became unhealthy. Waits that time out wrap context.DeadlineExceeded
+// instead.
+//
+//	var failed *compose.DependencyFailedError
+//	if errors.As(err, &failed) {
+//		log.Printf("%s: %s", failed.Dependency, failed.Reason)
+//	}
+type DependencyFailedError struct {
+	// Service is the service that was waiting.
+	Service    string
+	Dependency string
+	Condition  string
+	Reason     DependencyFailure
+	// ExitCode is the dependency's exit code when it exited or was
+	// OOM-killed.
+	ExitCode int
+}
+
+func (e *DependencyFailedError) Error() string {
+	switch e.Reason {
+	case DependencyExited:
+		return fmt.Sprintf("%s exited with code %d", e.Dependency, e.ExitCode)
+	case DependencyOOMKilled:
+		return fmt.Sprintf("%s was OOM-killed (exit code %d)", e.Dependency, e.ExitCode)
+	case DependencyUnhealthy:
+		return fmt.Sprintf("%s is unhealthy", e.Dependency)
+	}
+	return fmt.Sprintf("%s failed: %s", e.Dependency, e.Reason)
+}
+
+// runnerError is an error of the runner with its DependencyFailedError made
+// available to errors.As as this package's type.
+type runnerError struct {
+	err    error
+	failed *DependencyFailedError
+}
+
+func (e *runnerError) Error() string {
+	return e.err.Error()
+}
+
+func (e *runnerError) Unwrap() []error {
+	return []error{e.err, e.failed}
+}
+
+// convertError returns err with the runner's DependencyFailedError in it
+// converted, err itself when there's none.
+func convertError(err error) error {
+	var failed *runner.DependencyFailedError
+	if !errors.As(err, &failed) {
+		return err
+	}
+	return &runnerError{err: err, failed: &DependencyFailedError{
+		Service:    failed.Service,
+		Dependency: failed.Dependency,
+		Condition:  failed.Condition,
+		Reason:     DependencyFailure(failed.Reason),
+		ExitCode:   failed.ExitCode,
+	}}
+}
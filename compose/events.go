@@ -0,0 +1,174 @@
+package compose
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/JamesTiberiusKirk/go-docker-compose/internal/runner"
+)
+
+// EventType says which step of Up, Down or Restart an Event reports.
+type EventType string
+
+const (
+	// EventServiceQueued is emitted for every service, in dependency order,
+	// before Up starts working on any of them.
+	EventServiceQueued EventType = "ServiceQueued"
+	// EventServiceDone is emitted once Up is done with a service, whether it
+	// came up, failed or was cancelled.
+	EventServiceDone         EventType = "ServiceDone"
+	EventServicePreparing    EventType = "ServicePreparing"
+	EventDependencyWaiting   EventType = "DependencyWaiting"
+	EventDependencySatisfied EventType = "DependencySatisfied"
+	EventImageLocal          EventType = "ImageLocal"
+	EventPullStarted         EventType = "PullStarted"
+	EventPullProgress        EventType = "PullProgress"
+	EventPullComplete        EventType = "PullComplete"
+	EventBuildLog            EventType = "BuildLog"
+	EventImageBuilt          EventType = "ImageBuilt"
+	EventContainerRecreating EventType = "ContainerRecreating"
+	EventContainerUpToDate   EventType = "ContainerUpToDate"
+	EventContainerCreated    EventType = "ContainerCreated"
+	EventContainerStarted    EventType = "ContainerStarted"
+	EventContainerRestarted  EventType = "ContainerRestarted"
+	EventContainerStopping   EventType = "ContainerStopping"
+	EventContainerRemoved    EventType = "ContainerRemoved"
+	EventNetworkCreated      EventType = "NetworkCreated"
+	EventNetworkRemoved      EventType = "NetworkRemoved"
+	EventVolumeCreated       EventType = "VolumeCreated"
+	EventVolumeRemoved       EventType = "VolumeRemoved"
+	EventImageRemoved        EventType = "ImageRemoved"
+	// EventInfo carries a message that isn't tied to one of the steps above.
+	EventInfo  EventType = "Info"
+	EventError EventType = "Error"
+)
+
+// Event is one step of Up, Down or Restart, e.g. a pull making progress or a
+// container starting. Only the fields that apply to its Type are set.
+type Event struct {
+	Type EventType `json:"type"`
+	Time time.Time `json:"time"`
+	// Service is the service the event belongs to, empty for project wide
+	// resources like networks and volumes.
+	Service     string `json:"service,omitempty"`
+	ContainerID string `json:"container_id,omitempty"`
+	Image       string `json:"image,omitempty"`
+	Network     string `json:"network,omitempty"`
+	Volume      string `json:"volume,omitempty"`
+	// Dependency and Condition are set on the dependency events.
+	Dependency string `json:"dependency,omitempty"`
+	Condition  string `json:"condition,omitempty"`
+	// Layers, LayersDone, Current and Total are the aggregated progress of a
+	// pull, in layers and bytes.
+	Layers     int   `json:"layers,omitempty"`
+	LayersDone int   `json:"layers_done,omitempty"`
+	Current    int64 `json:"current,omitempty"`
+	Total      int64 `json:"total,omitempty"`
+	// Message is the human readable line the console prints for the event.
+	Message string `json:"message,omitempty"`
+	Err     error  `json:"-"`
+}
+
+// MarshalJSON adds Err as the "error" string.
+func (e Event) MarshalJSON() ([]byte, error) {
+	type plain Event
+	out := struct {
+		plain
+		Error string `json:"error,omitempty"`
+	}{plain: plain(e)}
+	if e.Err != nil {
+		out.Error = e.Err.Error()
+	}
+	return json.Marshal(out)
+}
+
+// EventSink receives the events of Up, Down and Restart. Within a single call
+// Handle is never called concurrently, but calls running at the same time and
+// sharing a sink do call it concurrently. The sinks of this package lock.
+type EventSink interface {
+	Handle(Event)
+}
+
+// EventSinkFunc lets a plain function be used as an EventSink.
+type EventSinkFunc func(Event)
+
+func (f EventSinkFunc) Handle(e Event) {
+	f(e)
+}
+
+// NewConsoleSink prints the events' messages to w, the default when no sink
+// is set. With tty set pull progress is redrawn in place.
+func NewConsoleSink(w io.Writer, tty bool) EventSink {
+	return runnerSinkAdapter{runner.NewConsoleSink(w, tty)}
+}
+
+// NewJSONSink writes every event to w as one JSON object per line.
+func NewJSONSink(w io.Writer) EventSink {
+	return runnerSinkAdapter{runner.NewJSONSink(w)}
+}
+
+// MultiSink passes every event to each of the sinks in turn.
+func MultiSink(sinks ...EventSink) EventSink {
+	return EventSinkFunc(func(e Event) {
+		for _, sink := range sinks {
+			sink.Handle(e)
+		}
+	})
+}
+
+// runnerSinkAdapter is one of the runner's sinks, handed to it as is when
+// passed back in the options.
+type runnerSinkAdapter struct {
+	sink runner.EventSink
+}
+
+func (a runnerSinkAdapter) Handle(e Event) {
+	a.sink.Handle(runner.Event{
+		Type:        runner.EventType(e.Type),
+		Time:        e.Time,
+		Service:     e.Service,
+		ContainerID: e.ContainerID,
+		Image:       e.Image,
+		Network:     e.Network,
+		Volume:      e.Volume,
+		Dependency:  e.Dependency,
+		Condition:   e.Condition,
+		Layers:      e.Layers,
+		LayersDone:  e.LayersDone,
+		Current:     e.Current,
+		Total:       e.Total,
+		Message:     e.Message,
+		Err:         e.Err,
+	})
+}
+
+// runnerSink returns the sink the runner reports to for sink, nil for the
+// runner's default.
+func runnerSink(sink EventSink) runner.EventSink {
+	switch s := sink.(type) {
+	case nil:
+		return nil
+	case runnerSinkAdapter:
+		return s.sink
+	}
+	return runner.EventSinkFunc(func(e runner.Event) {
+		sink.Handle(Event{
+			Type:        EventType(e.Type),
+			Time:        e.Time,
+			Service:     e.Service,
+			ContainerID: e.ContainerID,
+			Image:       e.Image,
+			Network:     e.Network,
+			Volume:      e.Volume,
+			Dependency:  e.Dependency,
+			Condition:   e.Condition,
+			Layers:      e.Layers,
+			LayersDone:  e.LayersDone,
+			Current:     e.Current,
+			Total:       e.Total,
+			Message:     e.Message,
+			Err:         convertError(e.Err),
+		})
+	})
+}
@@ -0,0 +1,145 @@
+package compose
+
+import (
+	"io"
+	"time"
+)
+
+// LoadOptions configures Load.
+type LoadOptions struct {
+	// Path is the compose file to load.
+	Path string
+	// Client talks to the Docker Engine. Defaults to a client configured from
+	// the DOCKER_* environment variables.
+	Client Engine
+	// NamePrefix and NameSuffix are added to every service name, which is also
+	// the container name.
+	NamePrefix string
+	NameSuffix string
+	// Env is used for variable interpolation in the compose file and wins over
+	// the system environment.
+	Env map[string]string
+	// PullEnvFromSystem adds the process environment to Env.
+	PullEnvFromSystem bool
+	// WorkingDir is the project directory relative paths are resolved against.
+	// Defaults to the directory of the compose file, like docker compose, not
+	// to the process's working directory.
+	WorkingDir string
+	// ProjectName defaults to the compose file's `name:` or, failing that,
+	// NamePrefix + the project directory name + NameSuffix.
+	ProjectName string
+	// Profiles enables the services with one of these profiles, "*" enables
+	// all of them. Services without profiles are always enabled.
+	Profiles []string
+}
+
+// UpOptions configures Project.Up.
+type UpOptions struct {
+	// ForceRecreate recreates every container even if its config hasn't changed.
+	ForceRecreate bool
+	// NoRecreate never recreates existing containers, stopped ones are started.
+	NoRecreate bool
+	// PullPolicy overrides the pull_policy of every service when set. Besides
+	// the compose values it accepts daily, weekly and every_<duration>. With
+	// build, services without a build section fall back to missing.
+	PullPolicy string
+	// MaxParallelism caps how many services are pulled, built and started at
+	// the same time. Zero means no limit.
+	MaxParallelism int
+	// DockerConfigDir is the directory holding the config.json registry
+	// credentials are read from. Defaults to $DOCKER_CONFIG or ~/.docker.
+	DockerConfigDir string
+	// Events receives a typed event for every step. Defaults to a console
+	// sink on stdout.
+	Events EventSink
+	// WaitTimeout bounds how long a service waits for each of its depends_on
+	// conditions. Zero waits until the context is done.
+	WaitTimeout time.Duration
+	// ServiceWaitTimeouts overrides WaitTimeout for the services it names,
+	// keyed by the service that waits, not by the one waited on. Each of the
+	// service's depends_on conditions gets the timeout.
+	ServiceWaitTimeouts map[string]time.Duration
+	// DiagnosticLogLines is how many of the last log lines of a dependency
+	// that failed or timed out are included in the error. Defaults to 20,
+	// negative leaves the logs out.
+	DiagnosticLogLines int
+	// Rollback makes a failed Up leave the project as it was found. The
+	// containers, networks and volumes it created are removed, containers it
+	// recreated are brought back and the ones it started are stopped again.
+	// A recreated container is kept, stopped and renamed, until Up is done.
+	// Pulled and built images are kept.
+	Rollback bool
+}
+
+// DownOptions configures Project.Down.
+type DownOptions struct {
+	// Timeout overrides every service's stop_grace_period when set.
+	Timeout *time.Duration
+	// RemoveImages removes the images built for services with a build section.
+	RemoveImages bool
+	// RemoveVolumes removes anonymous volumes and the project's named volumes.
+	RemoveVolumes bool
+	// RemoveNetworks removes the project's networks.
+	RemoveNetworks bool
+	// RemoveOrphans also removes project containers whose service is no longer
+	// in the compose file.
+	RemoveOrphans bool
+	// Events receives a typed event for every step. Defaults to a console
+	// sink on stdout.
+	Events EventSink
+}
+
+// RestartOptions configures Project.Restart.
+type RestartOptions struct {
+	// Timeout overrides every service's stop_grace_period when set.
+	Timeout *time.Duration
+	// WaitTimeout bounds how long a dependent waits for a restarted
+	// dependency's depends_on condition. Zero waits until the context is done.
+	WaitTimeout time.Duration
+	// Events receives a typed event for every step. Defaults to a console
+	// sink on stdout.
+	Events EventSink
+}
+
+// LogsOptions configures Project.Logs.
+type LogsOptions struct {
+	// Follow keeps streaming new output until the context is cancelled.
+	Follow bool
+	// Tail limits the output to the last N lines, empty or "all" shows everything.
+	Tail string
+	// Since only shows logs after a timestamp or a relative duration like 10m.
+	Since string
+	// Timestamps prefixes every line with its timestamp.
+	Timestamps bool
+}
+
+// ExecOptions configures Project.Exec.
+type ExecOptions struct {
+	// Env adds KEY=value variables to the command's environment.
+	Env        []string
+	User       string
+	WorkingDir string
+	Privileged bool
+	// Tty allocates a pseudo terminal, stdout and stderr are then merged.
+	Tty bool
+	// Stdin is copied to the command when set.
+	Stdin io.Reader
+	// Stdout and Stderr receive the command's output, which is discarded when
+	// they're nil.
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Container is a container of the project as listed by Project.Ps.
+type Container struct {
+	ID   string
+	Name string
+	// Service is the name of the service the container runs.
+	Service string
+	Image   string
+	// State is the container state, e.g. running or exited.
+	State string
+	// Status is the human readable status, e.g. "Up 5 minutes".
+	Status string
+	Labels map[string]string
+}
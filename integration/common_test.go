@@ -11,10 +11,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/JamesTiberiusKirk/go-docker-compose/internal/runner"
 	"github.com/compose-spec/compose-go/types"
 	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/api/types/filters"
-	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/client"
 	"github.com/stretchr/testify/require"
 )
@@ -124,36 +123,16 @@ func registerProjectCleanup(t *testing.T, cli *client.Client, project *types.Pro
 		ctx, cancel := context.WithTimeout(context.Background(), 45*time.Second)
 		defer cancel()
 
-		for _, svc := range project.Services {
-			// remove exact-name container if present
-			if _, err := cli.ContainerInspect(ctx, svc.Name); err == nil {
-				_ = cli.ContainerRemove(ctx, svc.Name, container.RemoveOptions{
-					Force:         true,
-					RemoveVolumes: true,
-				})
-			}
-			// sweep any strays still matching the name
-			args := filters.NewArgs()
-			args.Add("name", svc.Name)
-			if list, _ := cli.ContainerList(ctx, container.ListOptions{All: true, Filters: args}); len(list) > 0 {
-				for _, c := range list {
-					_ = cli.ContainerRemove(ctx, c.ID, container.RemoveOptions{
-						Force:         true,
-						RemoveVolumes: true,
-					})
-				}
-			}
-
-			// remove image if present
-			if svc.Image != "" {
-				if _, err := cli.ImageInspect(ctx, svc.Image); err == nil {
-					_, _ = cli.ImageRemove(ctx, svc.Image, image.RemoveOptions{
-						Force:         true,
-						PruneChildren: true,
-					})
-				}
-			}
+		zero := time.Duration(0)
+		err := runner.Down(ctx, cli, project, runner.DownOptions{
+			Timeout:        &zero,
+			RemoveImages:   true,
+			RemoveVolumes:  true,
+			RemoveNetworks: true,
+		})
+		require.NoError(t, err, "[CLEANUP] Error tearing down project")
 
+		for _, svc := range project.Services {
 			// remove bind dirs only if the test created them
 			for _, vol := range svc.Volumes {
 				if vol.Type == "bind" && vol.Source != "" && !preExisting[vol.Source] {
@@ -0,0 +1,74 @@
+package integrationtest
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JamesTiberiusKirk/go-docker-compose/compose"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teris-io/shortid"
+)
+
+// TestComposeAPI drives a project through the public compose package only.
+func TestComposeAPI(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 90*time.Second)
+	defer cancel()
+
+	sid, err := shortid.Generate()
+	require.NoError(t, err)
+	sid = strings.ToLower(sid)
+
+	project, err := compose.Load(ctx, compose.LoadOptions{
+		Path:       "test_docker_compose/api/api.yml",
+		NamePrefix: "stackr_test-",
+		NameSuffix: "-" + sid,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "stackr_test-api-"+sid, project.Name())
+	assert.Equal(t, []string{"stackr_test-web-" + sid}, project.Services())
+
+	t.Cleanup(func() {
+		zero := time.Duration(0)
+		if err := project.Down(context.Background(), compose.DownOptions{Timeout: &zero, RemoveVolumes: true, RemoveNetworks: true}); err != nil {
+			t.Logf("[CLEANUP] Error tearing down project: %v", err)
+		}
+	})
+
+	require.NoError(t, project.Up(ctx, compose.UpOptions{}))
+
+	containers, err := project.Ps(ctx)
+	require.NoError(t, err)
+	require.Len(t, containers, 1)
+	assert.Equal(t, "stackr_test-web-"+sid, containers[0].Name)
+	assert.Equal(t, "stackr_test-web-"+sid, containers[0].Service)
+	assert.Equal(t, "running", containers[0].State)
+
+	// Services can be addressed by their compose file name.
+	var stdout, stderr bytes.Buffer
+	code, err := project.Exec(ctx, "web", []string{"sh", "-c", "echo out; echo err >&2; exit 3"}, compose.ExecOptions{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 3, code)
+	assert.Equal(t, "out\n", stdout.String())
+	assert.Equal(t, "err\n", stderr.String())
+
+	require.Eventually(t, func() bool {
+		var logs bytes.Buffer
+		err := project.Logs(ctx, "web", &logs, &logs, compose.LogsOptions{})
+		return err == nil && strings.Contains(logs.String(), "hello from web")
+	}, 10*time.Second, 200*time.Millisecond)
+
+	_, err = project.Exec(ctx, "missing", []string{"true"}, compose.ExecOptions{})
+	require.ErrorContains(t, err, "no service missing")
+
+	require.NoError(t, project.Down(ctx, compose.DownOptions{}))
+	containers, err = project.Ps(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, containers)
+}
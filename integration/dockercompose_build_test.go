@@ -72,6 +72,35 @@ func TestCompose_Build(t *testing.T) {
 				assertContainerFileContent(t, cli, c.ID, "/message.txt", "Message from build arg!")
 			},
 		},
+		{
+			name:       "Build_with_dockerfile_outside_context",
+			composeYML: "test_docker_compose/build/build_dockerfile_outside.yml",
+			assertFunc: func(t *testing.T, cli *client.Client, c container.InspectResponse, sid string) {
+				assert.Equal(t, "stackr_test-app-dockerfile-outside-"+sid, c.Config.Image)
+				assertContainerLogs(t, cli, c.ID, "dockerfile outside context")
+				assertContainerFileContent(t, cli, c.ID, "/context-dockerfile", "Hello from custom build")
+			},
+		},
+		{
+			name:       "Build_honors_dockerignore",
+			composeYML: "test_docker_compose/build/build_dockerignore.yml",
+			assertFunc: func(t *testing.T, cli *client.Client, c container.InspectResponse, sid string) {
+				assert.Equal(t, "stackr_test-app-dockerignore-"+sid, c.Config.Image)
+				assertContainerLogs(t, cli, c.ID, "dockerignore applied")
+			},
+		},
+		{
+			name:       "Build_with_build_options",
+			composeYML: "test_docker_compose/build/build_options.yml",
+			assertFunc: func(t *testing.T, cli *client.Client, c container.InspectResponse, sid string) {
+				assertContainerLogs(t, cli, c.ID, "stage: dev")
+
+				img, err := cli.ImageInspect(t.Context(), c.Config.Image)
+				require.NoError(t, err)
+				assert.Equal(t, "go-docker-compose", img.Config.Labels["com.example.built-by"])
+				assert.Contains(t, img.RepoTags, "stackr_test_build_options_extra:latest")
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -0,0 +1,60 @@
+package integrationtest
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JamesTiberiusKirk/go-docker-compose/internal/composeconvert"
+	"github.com/JamesTiberiusKirk/go-docker-compose/internal/runner"
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teris-io/shortid"
+)
+
+func TestCompose_Down(t *testing.T) {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	sid, err := shortid.Generate()
+	require.NoError(t, err)
+	sid = strings.ToLower(sid)
+
+	project, err := composeconvert.LoadComposeStack(ctx, composeconvert.LoadComposeProjectOptions{
+		NamePrefix:        "stackr_test-",
+		NameSuffix:        "-" + sid,
+		DockerComposePath: "test_docker_compose/down/down.yml",
+	})
+	require.NoError(t, err, "Error from load compose stack")
+
+	registerProjectCleanup(t, cli, project)
+
+	require.NoError(t, runner.Run(ctx, cli, project), "Error running stack")
+
+	listed, err := runner.Ps(ctx, cli, project)
+	require.NoError(t, err)
+	assert.Len(t, listed, len(project.Services))
+
+	for _, svc := range project.Services {
+		info, err := cli.ContainerInspect(ctx, svc.Name)
+		require.NoError(t, err)
+		assert.True(t, info.State.Running, "%s should be running before down", svc.Name)
+	}
+
+	require.NoError(t, runner.Down(ctx, cli, project, runner.DownOptions{RemoveVolumes: true}))
+
+	for _, svc := range project.Services {
+		_, err := cli.ContainerInspect(ctx, svc.Name)
+		assert.Truef(t, cerrdefs.IsNotFound(err), "%s should have been removed, got: %v", svc.Name, err)
+	}
+
+	remaining, err := runner.Ps(ctx, cli, project)
+	require.NoError(t, err)
+	assert.Empty(t, remaining)
+}
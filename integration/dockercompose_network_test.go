@@ -0,0 +1,133 @@
+package integrationtest
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JamesTiberiusKirk/go-docker-compose/internal/composeconvert"
+	"github.com/JamesTiberiusKirk/go-docker-compose/internal/runner"
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teris-io/shortid"
+)
+
+func TestCompose_Networks(t *testing.T) {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 90*time.Second)
+	defer cancel()
+
+	sid, err := shortid.Generate()
+	require.NoError(t, err)
+	sid = strings.ToLower(sid)
+
+	project, err := composeconvert.LoadComposeStack(ctx, composeconvert.LoadComposeProjectOptions{
+		NamePrefix:        "stackr_test-",
+		NameSuffix:        "-" + sid,
+		DockerComposePath: "test_docker_compose/networks/networks.yml",
+	})
+	require.NoError(t, err, "Error from load compose stack")
+
+	registerProjectCleanup(t, cli, project)
+
+	require.NoError(t, runner.Run(ctx, cli, project), "Error running stack")
+	time.Sleep(2 * time.Second)
+
+	backendName := project.Networks["backend"].Name
+	backend, err := cli.NetworkInspect(ctx, backendName, network.InspectOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "yes", backend.Labels["com.example.net"])
+	assert.Equal(t, project.Name, backend.Labels[runner.ProjectLabel])
+	require.Len(t, backend.IPAM.Config, 1)
+	assert.Equal(t, "172.28.5.0/24", backend.IPAM.Config[0].Subnet)
+
+	apiInfo, err := cli.ContainerInspect(ctx, findServiceName(project, "api"))
+	require.NoError(t, err)
+	require.Contains(t, apiInfo.NetworkSettings.Networks, backendName)
+	assert.Equal(t, "172.28.5.10", apiInfo.NetworkSettings.Networks[backendName].IPAddress)
+
+	clientInfo, err := cli.ContainerInspect(ctx, findServiceName(project, "client"))
+	require.NoError(t, err)
+	assert.Contains(t, clientInfo.NetworkSettings.Networks, backendName)
+	assert.Contains(t, clientInfo.NetworkSettings.Networks, project.Networks["default"].Name)
+	assertContainerLogs(t, cli, clientInfo.ID, "NET_OK")
+
+	require.NoError(t, runner.Down(ctx, cli, project, runner.DownOptions{RemoveNetworks: true}))
+	_, err = cli.NetworkInspect(ctx, backendName, network.InspectOptions{})
+	assert.True(t, cerrdefs.IsNotFound(err), "network should have been removed, got: %v", err)
+}
+
+func TestCompose_NetworkMode(t *testing.T) {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 90*time.Second)
+	defer cancel()
+
+	sid, err := shortid.Generate()
+	require.NoError(t, err)
+	sid = strings.ToLower(sid)
+
+	project, err := composeconvert.LoadComposeStack(ctx, composeconvert.LoadComposeProjectOptions{
+		NamePrefix:        "stackr_test-",
+		NameSuffix:        "-" + sid,
+		DockerComposePath: "test_docker_compose/networks/network_mode.yml",
+	})
+	require.NoError(t, err, "Error from load compose stack")
+
+	registerProjectCleanup(t, cli, project)
+
+	require.NoError(t, runner.Run(ctx, cli, project), "Error running stack")
+	time.Sleep(2 * time.Second)
+
+	webName := findServiceName(project, "web")
+	sidecarInfo, err := cli.ContainerInspect(ctx, findServiceName(project, "sidecar"))
+	require.NoError(t, err)
+	assert.Equal(t, "container:"+webName, string(sidecarInfo.HostConfig.NetworkMode))
+	assertContainerLogs(t, cli, sidecarInfo.ID, "NET_OK")
+
+	hostInfo, err := cli.ContainerInspect(ctx, findServiceName(project, "host-net"))
+	require.NoError(t, err)
+	assert.True(t, hostInfo.HostConfig.NetworkMode.IsHost())
+
+	noneInfo, err := cli.ContainerInspect(ctx, findServiceName(project, "no-net"))
+	require.NoError(t, err)
+	assert.True(t, noneInfo.HostConfig.NetworkMode.IsNone())
+}
+
+func TestCompose_Links(t *testing.T) {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 90*time.Second)
+	defer cancel()
+
+	sid, err := shortid.Generate()
+	require.NoError(t, err)
+	sid = strings.ToLower(sid)
+
+	project, err := composeconvert.LoadComposeStack(ctx, composeconvert.LoadComposeProjectOptions{
+		NamePrefix:        "stackr_test-",
+		NameSuffix:        "-" + sid,
+		DockerComposePath: "test_docker_compose/networks/links.yml",
+	})
+	require.NoError(t, err, "Error from load compose stack")
+
+	registerProjectCleanup(t, cli, project)
+
+	require.NoError(t, runner.Run(ctx, cli, project), "Error running stack")
+	time.Sleep(2 * time.Second)
+
+	appInfo, err := cli.ContainerInspect(ctx, findServiceName(project, "app"))
+	require.NoError(t, err)
+	endpoint, ok := appInfo.NetworkSettings.Networks[project.Networks["default"].Name]
+	require.True(t, ok)
+	assert.Contains(t, endpoint.Links, findServiceName(project, "db")+":database")
+	assertContainerLogs(t, cli, appInfo.ID, "NET_OK")
+}
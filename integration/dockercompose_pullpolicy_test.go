@@ -0,0 +1,91 @@
+package integrationtest
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JamesTiberiusKirk/go-docker-compose/internal/composeconvert"
+	"github.com/JamesTiberiusKirk/go-docker-compose/internal/runner"
+	"github.com/compose-spec/compose-go/types"
+	"github.com/docker/docker/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teris-io/shortid"
+)
+
+func TestCompose_PullPolicy(t *testing.T) {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		composeYML string
+		opts       runner.UpOptions
+		assertFunc func(t *testing.T, runErr error, project *types.Project)
+	}{
+		{
+			name:       "Never_fails_on_missing_image",
+			composeYML: "test_docker_compose/pullpolicy/never_missing.yml",
+			assertFunc: func(t *testing.T, runErr error, project *types.Project) {
+				require.Error(t, runErr)
+				assert.Contains(t, runErr.Error(), "pull_policy is never")
+			},
+		},
+		{
+			name:       "Build_policy_builds_image",
+			composeYML: "test_docker_compose/pullpolicy/build.yml",
+			assertFunc: func(t *testing.T, runErr error, project *types.Project) {
+				require.NoError(t, runErr)
+				info, err := cli.ContainerInspect(t.Context(), project.Services[0].Name)
+				require.NoError(t, err)
+				assert.Equal(t, "stackr_test_pull_policy_build", info.Config.Image)
+			},
+		},
+		{
+			name:       "Daily_policy_loads_and_runs",
+			composeYML: "test_docker_compose/pullpolicy/daily.yml",
+			assertFunc: func(t *testing.T, runErr error, project *types.Project) {
+				require.NoError(t, runErr)
+				assert.Equal(t, runner.PullPolicyDaily, project.Services[0].PullPolicy)
+				info, err := cli.ContainerInspect(t.Context(), project.Services[0].Name)
+				require.NoError(t, err)
+				assert.True(t, info.State.Running)
+			},
+		},
+		{
+			name:       "Global_override_replaces_service_policy",
+			composeYML: "test_docker_compose/pullpolicy/daily.yml",
+			opts:       runner.UpOptions{PullPolicy: types.PullPolicyBuild},
+			assertFunc: func(t *testing.T, runErr error, project *types.Project) {
+				require.Error(t, runErr)
+				assert.Contains(t, runErr.Error(), "has pull_policy build but no build section")
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ctx, cancel := context.WithTimeout(context.Background(), 90*time.Second)
+			defer cancel()
+
+			sid, err := shortid.Generate()
+			require.NoError(t, err)
+			sid = strings.ToLower(sid)
+
+			project, err := composeconvert.LoadComposeStack(ctx, composeconvert.LoadComposeProjectOptions{
+				NamePrefix:        "stackr_test-",
+				NameSuffix:        "-" + sid,
+				DockerComposePath: tt.composeYML,
+			})
+			require.NoError(t, err, "Error from load compose stack")
+
+			registerProjectCleanup(t, cli, project)
+
+			runErr := runner.Up(ctx, cli, project, tt.opts)
+			tt.assertFunc(t, runErr, project)
+		})
+	}
+}
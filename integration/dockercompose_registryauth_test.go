@@ -0,0 +1,125 @@
+package integrationtest
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JamesTiberiusKirk/go-docker-compose/internal/composeconvert"
+	"github.com/JamesTiberiusKirk/go-docker-compose/internal/runner"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teris-io/shortid"
+)
+
+// TestCompose_RegistryAuth pushes an image to a local registry:2 protected by
+// htpasswd and pulls it back through Up with and without credentials.
+func TestCompose_RegistryAuth(t *testing.T) {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Minute)
+	defer cancel()
+
+	sid, err := shortid.Generate()
+	require.NoError(t, err)
+	sid = strings.ToLower(sid)
+
+	registryProject, err := composeconvert.LoadComposeStack(ctx, composeconvert.LoadComposeProjectOptions{
+		NamePrefix:        "stackr_test-",
+		NameSuffix:        "-" + sid,
+		DockerComposePath: "test_docker_compose/registryauth/registry.yml",
+	})
+	require.NoError(t, err)
+	registerProjectCleanup(t, cli, registryProject)
+	require.NoError(t, runner.Up(ctx, cli, registryProject, runner.UpOptions{}))
+
+	const user, password = "testuser", "testpassword"
+	privateImage := "localhost:5055/stackr-auth-test:" + sid
+	pushAuth, err := registry.EncodeAuthConfig(registry.AuthConfig{
+		Username:      user,
+		Password:      password,
+		ServerAddress: "localhost:5055",
+	})
+	require.NoError(t, err)
+
+	pullAlpine, err := cli.ImagePull(ctx, "alpine:latest", image.PullOptions{})
+	require.NoError(t, err)
+	_, _ = io.Copy(io.Discard, pullAlpine)
+	pullAlpine.Close()
+	require.NoError(t, cli.ImageTag(ctx, "alpine:latest", privateImage))
+
+	// The registry needs a moment before it accepts pushes.
+	require.Eventually(t, func() bool {
+		push, err := cli.ImagePush(ctx, privateImage, image.PushOptions{RegistryAuth: pushAuth})
+		if err != nil {
+			return false
+		}
+		defer push.Close()
+		body, err := io.ReadAll(push)
+		return err == nil && !strings.Contains(string(body), `"error"`)
+	}, 30*time.Second, time.Second)
+
+	removePrivateImage := func() {
+		_, _ = cli.ImageRemove(context.Background(), privateImage, image.RemoveOptions{Force: true})
+	}
+	removePrivateImage()
+	t.Cleanup(removePrivateImage)
+
+	authFile := base64.StdEncoding.EncodeToString([]byte(user + ":" + password))
+	tests := []struct {
+		name         string
+		dockerConfig string
+		expectErr    bool
+	}{
+		{
+			name:         "Pull_with_credentials",
+			dockerConfig: `{"auths":{"localhost:5055":{"auth":"` + authFile + `"}}}`,
+		},
+		{
+			name:         "Pull_without_credentials_fails",
+			dockerConfig: `{}`,
+			expectErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configDir := t.TempDir()
+			require.NoError(t, os.WriteFile(filepath.Join(configDir, "config.json"), []byte(tt.dockerConfig), 0600))
+
+			sid, err := shortid.Generate()
+			require.NoError(t, err)
+			sid = strings.ToLower(sid)
+
+			project, err := composeconvert.LoadComposeStack(ctx, composeconvert.LoadComposeProjectOptions{
+				NamePrefix:        "stackr_test-",
+				NameSuffix:        "-" + sid,
+				DockerComposePath: "test_docker_compose/registryauth/private.yml",
+				Env:               map[string]string{"PRIVATE_IMAGE": privateImage},
+			})
+			require.NoError(t, err)
+			registerProjectCleanup(t, cli, project)
+
+			runErr := runner.Up(ctx, cli, project, runner.UpOptions{DockerConfigDir: configDir})
+			if tt.expectErr {
+				require.Error(t, runErr)
+				return
+			}
+			require.NoError(t, runErr)
+
+			info, err := cli.ContainerInspect(ctx, project.Services[0].Name)
+			require.NoError(t, err)
+			assert.Equal(t, privateImage, info.Config.Image)
+			removePrivateImage()
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package integrationtest
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JamesTiberiusKirk/go-docker-compose/internal/composeconvert"
+	"github.com/JamesTiberiusKirk/go-docker-compose/internal/runner"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teris-io/shortid"
+)
+
+func TestCompose_Security(t *testing.T) {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		composeYML string
+		// service is the one inspected, as named in the compose file.
+		service    string
+		assertFunc func(t *testing.T, c container.InspectResponse, sid string)
+	}{
+		{
+			name:       "Capabilities_and_namespaces",
+			composeYML: "test_docker_compose/security/security.yml",
+			service:    "security-test",
+			assertFunc: func(t *testing.T, c container.InspectResponse, sid string) {
+				assert.Equal(t, []string{"NET_ADMIN"}, []string(c.HostConfig.CapAdd))
+				assert.Equal(t, []string{"MKNOD"}, []string(c.HostConfig.CapDrop))
+				assert.Contains(t, c.HostConfig.SecurityOpt, "no-new-privileges:true")
+				assert.True(t, c.HostConfig.ReadonlyRootfs)
+				assert.Equal(t, container.PidMode("host"), c.HostConfig.PidMode)
+				assert.Equal(t, container.UTSMode("host"), c.HostConfig.UTSMode)
+				assert.Equal(t, container.CgroupnsMode("private"), c.HostConfig.CgroupnsMode)
+				assert.Equal(t, "stackr_test.slice", c.HostConfig.CgroupParent)
+				assert.False(t, c.HostConfig.Privileged)
+			},
+		},
+		{
+			name:       "Privileged",
+			composeYML: "test_docker_compose/security/privileged.yml",
+			service:    "privileged-test",
+			assertFunc: func(t *testing.T, c container.InspectResponse, sid string) {
+				assert.True(t, c.HostConfig.Privileged)
+				assert.Equal(t, container.UsernsMode("host"), c.HostConfig.UsernsMode)
+			},
+		},
+		{
+			name:       "IPC_and_PID_of_another_service",
+			composeYML: "test_docker_compose/security/ipc.yml",
+			service:    "ipc-guest",
+			assertFunc: func(t *testing.T, c container.InspectResponse, sid string) {
+				owner := "stackr_test-ipc-owner-" + sid
+				assert.Equal(t, container.IpcMode("container:"+owner), c.HostConfig.IpcMode)
+				assert.Equal(t, container.PidMode("container:"+owner), c.HostConfig.PidMode)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ctx, cancel := context.WithTimeout(t.Context(), 60*time.Second)
+			defer cancel()
+
+			sid, err := shortid.Generate()
+			require.NoError(t, err)
+			sid = strings.ToLower(sid)
+
+			project, err := composeconvert.LoadComposeStack(ctx, composeconvert.LoadComposeProjectOptions{
+				NamePrefix:        "stackr_test-",
+				NameSuffix:        "-" + sid,
+				DockerComposePath: tt.composeYML,
+			})
+			require.NoError(t, err, "Error from load compose stack")
+
+			registerProjectCleanup(t, cli, project)
+
+			require.NoError(t, runner.Run(ctx, cli, project), "Error running stack")
+
+			info, err := cli.ContainerInspect(ctx, "stackr_test-"+tt.service+"-"+sid)
+			require.NoError(t, err, "Error inspecting container")
+
+			tt.assertFunc(t, info, sid)
+		})
+	}
+}
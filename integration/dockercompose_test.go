@@ -2,7 +2,6 @@ package integrationtest
 
 import (
 	"context"
-	"os"
 	"strings"
 	"testing"
 	"time"
@@ -10,7 +9,6 @@ import (
 	"github.com/JamesTiberiusKirk/go-docker-compose/internal/composeconvert"
 	"github.com/JamesTiberiusKirk/go-docker-compose/internal/runner"
 	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/client"
 	"github.com/docker/go-connections/nat"
 	"github.com/stretchr/testify/assert"
@@ -50,6 +48,10 @@ func TestCompose(t *testing.T) {
 				labels := c.Config.Labels
 				assert.Equal(t, "true", labels["com.example.test"])
 				assert.Equal(t, "v1", labels["version"])
+				assert.Equal(t, "stackr_test-test_docker_compose-"+sid, labels[runner.ProjectLabel])
+				assert.Equal(t, "stackr_test-label-test-"+sid, labels[runner.ServiceLabel])
+				assert.NotEmpty(t, labels[runner.ConfigHashLabel])
+				assert.True(t, strings.HasSuffix(labels[runner.ConfigFilesLabel], "labels.yml"))
 			},
 		},
 		{
@@ -77,6 +79,35 @@ func TestCompose(t *testing.T) {
 				assert.True(t, found, "volume /usr/share/nginx/html bound from .docker-mount")
 			},
 		},
+		{
+			name:       "Volumes_long_syntax",
+			composeYML: "test_docker_compose/volumes_long.yml",
+			assertFunc: func(t *testing.T, c container.InspectResponse, sid string) {
+				mounts := map[string]container.MountPoint{}
+				for _, m := range c.Mounts {
+					mounts[m.Destination] = m
+				}
+
+				data, ok := mounts["/data"]
+				require.True(t, ok, "named volume mounted at /data")
+				assert.Equal(t, "volume", string(data.Type))
+				assert.Equal(t, "stackr_test-test_docker_compose-"+sid+"_data", data.Name)
+				assert.True(t, data.RW)
+
+				cfg, ok := mounts["/etc/compose-volumes.yml"]
+				require.True(t, ok, "bind mounted at /etc/compose-volumes.yml")
+				assert.Equal(t, "bind", string(cfg.Type))
+				assert.False(t, cfg.RW, "bind mount should be read only")
+
+				var tmpfs bool
+				for _, m := range c.HostConfig.Mounts {
+					if m.Target == "/scratch" && m.Type == "tmpfs" {
+						tmpfs = true
+					}
+				}
+				assert.True(t, tmpfs, "tmpfs mounted at /scratch")
+			},
+		},
 		{
 			name:       "Hostname",
 			composeYML: "test_docker_compose/hostname.yml",
@@ -84,6 +115,62 @@ func TestCompose(t *testing.T) {
 				assert.Equal(t, "stackr_test-hostname-test-"+sid, c.Config.Hostname)
 			},
 		},
+		{
+			name:       "User",
+			composeYML: "test_docker_compose/user.yml",
+			assertFunc: func(t *testing.T, c container.InspectResponse, sid string) {
+				assert.Equal(t, "1000:1000", c.Config.User)
+			},
+		},
+		{
+			name:       "Process_fields",
+			composeYML: "test_docker_compose/process.yml",
+			assertFunc: func(t *testing.T, c container.InspectResponse, sid string) {
+				assert.Equal(t, []string{"sleep"}, []string(c.Config.Entrypoint))
+				assert.Equal(t, []string{"300"}, []string(c.Config.Cmd))
+				assert.Equal(t, "/tmp", c.Config.WorkingDir)
+				assert.Equal(t, "1000:1000", c.Config.User)
+				assert.Equal(t, []string{"2000"}, c.HostConfig.GroupAdd)
+				assert.True(t, c.Config.Tty)
+				assert.True(t, c.Config.OpenStdin)
+				require.NotNil(t, c.HostConfig.Init)
+				assert.True(t, *c.HostConfig.Init)
+				assert.Equal(t, "SIGINT", c.Config.StopSignal)
+				require.NotNil(t, c.Config.StopTimeout)
+				assert.Equal(t, 3, *c.Config.StopTimeout)
+				assert.Equal(t, "custom-host", c.Config.Hostname)
+				assert.Equal(t, "example.test", c.Config.Domainname)
+			},
+		},
+		{
+			name:       "Resources",
+			composeYML: "test_docker_compose/resources.yml",
+			assertFunc: func(t *testing.T, c container.InspectResponse, sid string) {
+				res := c.HostConfig.Resources
+				assert.Equal(t, int64(64<<20), res.Memory)
+				assert.Equal(t, int64(128<<20), res.MemorySwap)
+				assert.Equal(t, int64(32<<20), res.MemoryReservation)
+				assert.Equal(t, int64(500_000_000), res.NanoCPUs)
+				assert.Equal(t, int64(512), res.CPUShares)
+				require.NotNil(t, res.PidsLimit)
+				assert.Equal(t, int64(100), *res.PidsLimit)
+				assert.Equal(t, []*container.Ulimit{{Name: "nofile", Soft: 1024, Hard: 2048}}, res.Ulimits)
+				assert.Equal(t, int64(32<<20), c.HostConfig.ShmSize)
+				assert.Equal(t, 100, c.HostConfig.OomScoreAdj)
+				assert.Equal(t, "1024", c.HostConfig.Sysctls["net.core.somaxconn"])
+			},
+		},
+		{
+			name:       "DNS_and_extra_hosts",
+			composeYML: "test_docker_compose/dns.yml",
+			assertFunc: func(t *testing.T, c container.InspectResponse, sid string) {
+				assert.Equal(t, []string{"1.1.1.1"}, c.HostConfig.DNS)
+				assert.Equal(t, []string{"example.test"}, c.HostConfig.DNSSearch)
+				assert.Equal(t, []string{"use-vc"}, c.HostConfig.DNSOptions)
+				assert.Contains(t, c.HostConfig.ExtraHosts, "host.docker.internal:host-gateway")
+				assert.Contains(t, c.HostConfig.ExtraHosts, "db.local:10.0.0.5")
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -104,32 +191,7 @@ func TestCompose(t *testing.T) {
 			})
 			require.NoError(t, err, "Error from load compose stack")
 
-			t.Cleanup(func() {
-				ctx, cleanupCancel := context.WithTimeout(context.Background(), 30*time.Second)
-				defer cleanupCancel()
-				for _, svc := range project.Services {
-
-					_, err := cli.ContainerInspect(ctx, svc.Name)
-					if err == nil {
-						err = cli.ContainerRemove(ctx, svc.Name, container.RemoveOptions{Force: true})
-						require.NoError(t, err, "[CLEANUP] Error removing container")
-					}
-
-					_, err = cli.ImageInspect(ctx, svc.Image)
-					if err == nil {
-						_, err = cli.ImageRemove(ctx, svc.Image, image.RemoveOptions{Force: true, PruneChildren: true})
-						require.NoError(t, err, "[CLEANUP] Error removing image")
-					}
-
-					for _, vol := range svc.Volumes {
-						if vol.Type == "bind" && vol.Source != "" {
-							err = os.RemoveAll(vol.Source)
-							require.NoError(t, err, "[CLEANUP] Error removing bind folder")
-							require.NoDirExists(t, vol.Source, "[CLEANUP] Error directory still exists")
-						}
-					}
-				}
-			})
+			registerProjectCleanup(t, cli, project)
 
 			require.NoError(t, runner.Run(ctx, cli, project), "Error running stack")
 			time.Sleep(2 * time.Second)
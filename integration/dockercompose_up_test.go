@@ -0,0 +1,141 @@
+package integrationtest
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JamesTiberiusKirk/go-docker-compose/internal/composeconvert"
+	"github.com/JamesTiberiusKirk/go-docker-compose/internal/runner"
+	"github.com/compose-spec/compose-go/types"
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teris-io/shortid"
+)
+
+func TestCompose_UpConverge(t *testing.T) {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 90*time.Second)
+	defer cancel()
+
+	sid, err := shortid.Generate()
+	require.NoError(t, err)
+	sid = strings.ToLower(sid)
+
+	project, err := composeconvert.LoadComposeStack(ctx, composeconvert.LoadComposeProjectOptions{
+		NamePrefix:        "stackr_test-",
+		NameSuffix:        "-" + sid,
+		DockerComposePath: "test_docker_compose/healthcheck/no_healthcheck.yml",
+	})
+	require.NoError(t, err, "Error from load compose stack")
+
+	registerProjectCleanup(t, cli, project)
+
+	name := project.Services[0].Name
+	containerID := func() string {
+		info, err := cli.ContainerInspect(ctx, name)
+		require.NoError(t, err)
+		return info.ID
+	}
+
+	require.NoError(t, runner.Run(ctx, cli, project), "Error running stack")
+	firstID := containerID()
+
+	// Unchanged config: the container is kept.
+	require.NoError(t, runner.Run(ctx, cli, project), "Error re-running stack")
+	assert.Equal(t, firstID, containerID())
+
+	// Stopped container is started again rather than recreated.
+	require.NoError(t, cli.ContainerStop(ctx, firstID, container.StopOptions{}))
+	require.NoError(t, runner.Run(ctx, cli, project))
+	info, err := cli.ContainerInspect(ctx, name)
+	require.NoError(t, err)
+	assert.Equal(t, firstID, info.ID)
+	assert.True(t, info.State.Running)
+
+	// NoRecreate keeps the container even when the config changed.
+	project.Services[0].Environment = map[string]*string{"CHANGED": &sid}
+	require.NoError(t, runner.Up(ctx, cli, project, runner.UpOptions{NoRecreate: true}))
+	assert.Equal(t, firstID, containerID())
+
+	// Changed config: the container is recreated.
+	require.NoError(t, runner.Run(ctx, cli, project))
+	changedID := containerID()
+	assert.NotEqual(t, firstID, changedID)
+
+	// ForceRecreate recreates even when nothing changed.
+	require.NoError(t, runner.Up(ctx, cli, project, runner.UpOptions{ForceRecreate: true}))
+	assert.NotEqual(t, changedID, containerID())
+}
+
+func TestCompose_UpParallel(t *testing.T) {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		composeYML string
+		assertFunc func(t *testing.T, runErr error, project *types.Project)
+	}{
+		{
+			name:       "Dependent_starts_after_all_parallel_dependencies",
+			composeYML: "test_docker_compose/parallel/parallel.yml",
+			assertFunc: func(t *testing.T, runErr error, project *types.Project) {
+				require.NoError(t, runErr)
+
+				joined, err := cli.ContainerInspect(t.Context(), findServiceName(project, "joined"))
+				require.NoError(t, err)
+				joinedStarted := mustParseDockerTime(t, joined.State.StartedAt)
+
+				for _, dep := range []string{"one", "two", "three"} {
+					info, err := cli.ContainerInspect(t.Context(), findServiceName(project, dep))
+					require.NoError(t, err)
+					assert.True(t, info.State.Running)
+					depStarted := mustParseDockerTime(t, info.State.StartedAt)
+					assert.Truef(t, !joinedStarted.Before(depStarted), "joined started at %v, before %s at %v", joinedStarted, dep, depStarted)
+				}
+			},
+		},
+		{
+			name:       "First_error_stops_dependents",
+			composeYML: "test_docker_compose/parallel/failing.yml",
+			assertFunc: func(t *testing.T, runErr error, project *types.Project) {
+				require.Error(t, runErr)
+				assert.Contains(t, runErr.Error(), "stackr-test-image-that-does-not-exist")
+
+				_, err := cli.ContainerInspect(t.Context(), findServiceName(project, "after"))
+				assert.True(t, cerrdefs.IsNotFound(err), "dependent of a failed service must not be created")
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ctx, cancel := context.WithTimeout(context.Background(), 90*time.Second)
+			defer cancel()
+
+			sid, err := shortid.Generate()
+			require.NoError(t, err)
+			sid = strings.ToLower(sid)
+
+			project, err := composeconvert.LoadComposeStack(ctx, composeconvert.LoadComposeProjectOptions{
+				NamePrefix:        "stackr_test-",
+				NameSuffix:        "-" + sid,
+				DockerComposePath: tt.composeYML,
+			})
+			require.NoError(t, err, "Error from load compose stack")
+
+			registerProjectCleanup(t, cli, project)
+
+			runErr := runner.Up(ctx, cli, project, runner.UpOptions{MaxParallelism: 2})
+			tt.assertFunc(t, runErr, project)
+		})
+	}
+}
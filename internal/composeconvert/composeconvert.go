@@ -4,22 +4,39 @@ import (
 	"context"
 	"fmt"
 	"maps"
+	"math"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/compose-spec/compose-go/loader"
+	"github.com/compose-spec/compose-go/template"
 	"github.com/compose-spec/compose-go/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/api/types/strslice"
 	"github.com/docker/go-connections/nat"
+	"github.com/docker/go-units"
 	"gopkg.in/yaml.v3"
 )
 
+// BuildShmSizeExtension is where the loader keeps `build.shm_size` in bytes,
+// compose-go's BuildConfig has no field for it.
+const BuildShmSizeExtension = "x-go-docker-compose-shm-size"
+
+// OptionalDependencyExtension marks the depends_on entries the compose file
+// set `required: false` on. A ServiceDependency built by hand has Required
+// false too, so only the mark makes a dependency optional.
+const OptionalDependencyExtension = "x-go-docker-compose-optional"
+
+// OriginalNameLabel holds the service's name as written in the compose file,
+// before NamePrefix and NameSuffix were applied.
+const OriginalNameLabel = "go-docker-compose.original-name"
+
 type LoadComposeProjectOptions struct {
 	DockerComposePath string
 	NamePrefix        string
@@ -27,7 +44,17 @@ type LoadComposeProjectOptions struct {
 	// This will overwrite any existing env pulled from system (if its enabled)
 	Env               map[string]string
 	PullEnvFromSystem bool
-	WorkingDir        string
+	// WorkingDir is the project directory relative paths in the compose file
+	// are resolved against. Defaults to the directory of the compose file,
+	// like docker compose, not to the process's working directory.
+	WorkingDir string
+	// ProjectName is stamped on every resource the runner creates and is used to
+	// find them again. Defaults to the compose file's `name:` or, failing that,
+	// NamePrefix + the project directory name + NameSuffix.
+	ProjectName string
+	// Profiles enables the services with one of these profiles, "*" enables
+	// all of them. Services without profiles are always enabled.
+	Profiles []string
 }
 
 func LoadComposeStack(ctx context.Context, ops LoadComposeProjectOptions) (*types.Project, error) {
@@ -54,27 +81,94 @@ func LoadComposeStack(ctx context.Context, ops LoadComposeProjectOptions) (*type
 	}
 	maps.Copy(env, ops.Env)
 
-	workingDir := ops.WorkingDir
-	if workingDir == "" {
-		pwd, err := os.Getwd()
-		if err != nil {
-			return nil, fmt.Errorf("failed to get pwd: %w", err)
+	composePath, err := filepath.Abs(ops.DockerComposePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve compose file path: %w", err)
+	}
+
+	// Relative paths in the compose file (build contexts, bind mounts,
+	// env_files, ...) resolve against the project directory, which is the
+	// compose file's directory unless set explicitly.
+	projectDir := ops.WorkingDir
+	if projectDir == "" {
+		projectDir = filepath.Dir(composePath)
+	}
+	projectDir, err = filepath.Abs(projectDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve project directory: %w", err)
+	}
+
+	projectName := ops.ProjectName
+	if projectName == "" {
+		if name, ok := raw["name"].(string); ok {
+			projectName = name
 		}
-		workingDir = pwd
 	}
+	if projectName == "" {
+		projectName = ops.NamePrefix + filepath.Base(projectDir) + ops.NameSuffix
+	}
+	projectName = loader.NormalizeProjectName(projectName)
 
-	composeDir := filepath.Dir(ops.DockerComposePath)
+	pullPolicies := extractPeriodicPullPolicies(raw)
+	dropUndefinedOptionalDependencies(raw)
 
 	project, err := loader.Load(types.ConfigDetails{
-		WorkingDir: composeDir,
+		WorkingDir: projectDir,
 		ConfigFiles: []types.ConfigFile{
 			{Filename: ops.DockerComposePath, Config: raw},
 		},
 		Environment: env,
+	}, func(o *loader.Options) {
+		o.SetProjectName(projectName, true)
+		o.Profiles = ops.Profiles
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to load compose project: %w", err)
 	}
+	project.ComposeFiles = []string{composePath}
+
+	if err := copyBuildShmSize(raw, env, project.Services); err != nil {
+		return nil, err
+	}
+	for i := range project.Services {
+		if policy, ok := pullPolicies[project.Services[i].Name]; ok {
+			project.Services[i].PullPolicy = policy
+		}
+	}
+
+	// Catch conflicting resource limits when loading rather than on Up.
+	for _, service := range project.Services {
+		if _, err := translateResources(service); err != nil {
+			return nil, err
+		}
+	}
+
+	// Remember the compose file names so services can still be reached by them
+	// on the project networks once renamed.
+	for i := range project.Services {
+		project.Services[i].CustomLabels = project.Services[i].CustomLabels.Add(OriginalNameLabel, project.Services[i].Name)
+	}
+
+	// The loader defaults required to true, so the dependencies left without
+	// it were set `required: false` in the file. Optional dependencies on
+	// services disabled by profile are dropped, as if they weren't in the
+	// file at all.
+	for i := range project.Services {
+		for dep, cfg := range project.Services[i].DependsOn {
+			if cfg.Required {
+				continue
+			}
+			if _, err := project.GetService(dep); err != nil {
+				delete(project.Services[i].DependsOn, dep)
+				continue
+			}
+			if cfg.Extensions == nil {
+				cfg.Extensions = types.Extensions{}
+			}
+			cfg.Extensions[OptionalDependencyExtension] = true
+			project.Services[i].DependsOn[dep] = cfg
+		}
+	}
 
 	// 1) Sort services BEFORE renaming so DependsOn keys still match original names.
 	orderedServices, err := topoSortServices(project.Services)
@@ -111,6 +205,12 @@ func LoadComposeStack(ctx context.Context, ops LoadComposeProjectOptions) (*type
 				}
 				orderedServices[i].DependsOn = m
 			}
+			// rewrite service: references, the loader already made them
+			// dependencies
+			orderedServices[i].Links = renameLinks(orderedServices[i].Links, nameMap)
+			orderedServices[i].NetworkMode = renameServiceRef(orderedServices[i].NetworkMode, nameMap)
+			orderedServices[i].Ipc = renameServiceRef(orderedServices[i].Ipc, nameMap)
+			orderedServices[i].Pid = renameServiceRef(orderedServices[i].Pid, nameMap)
 		}
 	}
 
@@ -118,30 +218,320 @@ func LoadComposeStack(ctx context.Context, ops LoadComposeProjectOptions) (*type
 	return project, nil
 }
 
-func TranslateServiceConfigToContainerConfig(service types.ServiceConfig) (*container.Config, *container.HostConfig, *network.NetworkingConfig, error) {
+// copyBuildShmSize carries `build.shm_size` over from the raw compose document
+// into the build config's extensions, as compose-go drops it while loading.
+// The raw document isn't interpolated yet, so variables in the value are
+// substituted from env here.
+func copyBuildShmSize(raw map[string]any, env map[string]string, services types.Services) error {
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	rawServices, _ := raw["services"].(map[string]any)
+	for i := range services {
+		if services[i].Build == nil {
+			continue
+		}
+		rawService, _ := rawServices[services[i].Name].(map[string]any)
+		rawBuild, _ := rawService["build"].(map[string]any)
+		value, ok := rawBuild["shm_size"]
+		if !ok {
+			continue
+		}
+
+		var size int64
+		switch v := value.(type) {
+		case int:
+			size = int64(v)
+		case int64:
+			size = v
+		case uint64:
+			size = int64(v)
+		case float64:
+			if v != math.Trunc(v) {
+				return fmt.Errorf("invalid build.shm_size %v for service %s: not a whole number of bytes", v, services[i].Name)
+			}
+			size = int64(v)
+		case string:
+			substituted, err := template.Substitute(v, lookup)
+			if err != nil {
+				return fmt.Errorf("invalid build.shm_size %q for service %s: %w", v, services[i].Name, err)
+			}
+			parsed, err := units.RAMInBytes(substituted)
+			if err != nil {
+				return fmt.Errorf("invalid build.shm_size %q for service %s: %w", substituted, services[i].Name, err)
+			}
+			size = parsed
+		default:
+			return fmt.Errorf("invalid build.shm_size %v for service %s", v, services[i].Name)
+		}
+
+		if services[i].Build.Extensions == nil {
+			services[i].Build.Extensions = types.Extensions{}
+		}
+		services[i].Build.Extensions[BuildShmSizeExtension] = size
+	}
+	return nil
+}
+
+// extractPeriodicPullPolicies takes the daily, weekly and every_<duration>
+// pull policies out of the raw compose document, which compose-go's schema
+// doesn't accept, and returns them by service name. They're swapped for
+// "missing" so the document validates and put back once it's loaded.
+func extractPeriodicPullPolicies(raw map[string]any) map[string]string {
+	policies := map[string]string{}
+	rawServices, _ := raw["services"].(map[string]any)
+	for name, rawService := range rawServices {
+		svc, _ := rawService.(map[string]any)
+		policy, _ := svc["pull_policy"].(string)
+		if policy == "daily" || policy == "weekly" || strings.HasPrefix(policy, "every_") {
+			policies[name] = policy
+			svc["pull_policy"] = types.PullPolicyMissing
+		}
+	}
+	return policies
+}
+
+// dropUndefinedOptionalDependencies removes the depends_on entries marked
+// `required: false` that name a service not in the raw compose document.
+// compose-go rejects any dependency on an undefined service, the spec says
+// optional ones are ignored.
+func dropUndefinedOptionalDependencies(raw map[string]any) {
+	rawServices, _ := raw["services"].(map[string]any)
+	for _, rawService := range rawServices {
+		svc, _ := rawService.(map[string]any)
+		// Only the long syntax can mark a dependency optional.
+		deps, _ := svc["depends_on"].(map[string]any)
+		for name, rawDep := range deps {
+			if _, ok := rawServices[name]; ok {
+				continue
+			}
+			dep, _ := rawDep.(map[string]any)
+			if required, ok := dep["required"]; ok && (required == false || required == "false") {
+				delete(deps, name)
+			}
+		}
+	}
+}
+
+// TranslateUlimits converts compose ulimits into docker's, sorted by name.
+func TranslateUlimits(ulimits map[string]*types.UlimitsConfig) []*container.Ulimit {
+	names := make([]string, 0, len(ulimits))
+	for name := range ulimits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var result []*container.Ulimit
+	for _, name := range names {
+		u := ulimits[name]
+		if u == nil {
+			continue
+		}
+		soft, hard := int64(u.Soft), int64(u.Hard)
+		if u.Single != 0 {
+			soft, hard = int64(u.Single), int64(u.Single)
+		}
+		result = append(result, &container.Ulimit{Name: name, Soft: soft, Hard: hard})
+	}
+	return result
+}
+
+// TranslateExtraHosts renders extra_hosts as sorted `host:ip` entries.
+func TranslateExtraHosts(hosts types.HostsList) []string {
+	if len(hosts) == 0 {
+		return nil
+	}
+	list := hosts.AsList()
+	sort.Strings(list)
+	return list
+}
+
+// renameServiceRef renames the service a `service:<name>` reference points
+// to, other values are returned as they are.
+func renameServiceRef(ref string, nameMap map[string]string) string {
+	name, ok := strings.CutPrefix(ref, types.ServicePrefix)
+	if !ok {
+		return ref
+	}
+	if newName, ok := nameMap[name]; ok {
+		return types.ServicePrefix + newName
+	}
+	return ref
+}
+
+// NamespaceDependencies returns the services whose network, IPC or PID
+// namespace the service joins through a `service:<name>` reference, sorted.
+// Their containers have to exist before the service's is created.
+func NamespaceDependencies(service types.ServiceConfig) []string {
+	var deps []string
+	for _, ref := range []string{service.NetworkMode, service.Ipc, service.Pid} {
+		if name, ok := strings.CutPrefix(ref, types.ServicePrefix); ok && !slices.Contains(deps, name) {
+			deps = append(deps, name)
+		}
+	}
+	sort.Strings(deps)
+	return deps
+}
+
+// renameLinks renames the services links point to. A link without an alias
+// keeps the service's name from the compose file as its alias.
+func renameLinks(links []string, nameMap map[string]string) []string {
+	if len(links) == 0 {
+		return links
+	}
+	renamed := make([]string, 0, len(links))
+	for _, link := range links {
+		name, alias, ok := strings.Cut(link, ":")
+		if !ok {
+			alias = name
+		}
+		if newName, ok := nameMap[name]; ok {
+			name = newName
+		}
+		renamed = append(renamed, name+":"+alias)
+	}
+	return renamed
+}
+
+// serviceRefToContainer turns a `service:<name>` namespace reference into the
+// `container:<name>` docker understands, the container has the service's
+// name.
+func serviceRefToContainer(mode string) string {
+	if name, ok := strings.CutPrefix(mode, types.ServicePrefix); ok {
+		return types.ContainerPrefix + name
+	}
+	return mode
+}
+
+// translateEntrypoint converts the entrypoint. An explicitly empty one
+// (`entrypoint: []` or `""`) clears the image's entrypoint, which the API
+// only does for [""].
+func translateEntrypoint(entrypoint types.ShellCommand) strslice.StrSlice {
+	if entrypoint != nil && len(entrypoint) == 0 {
+		return strslice.StrSlice{""}
+	}
+	return strslice.StrSlice(entrypoint)
+}
+
+// OriginalServiceName returns the name the service had in the compose file.
+func OriginalServiceName(service types.ServiceConfig) string {
+	if name, ok := service.CustomLabels[OriginalNameLabel]; ok {
+		return name
+	}
+	return service.Name
+}
+
+func TranslateServiceConfigToContainerConfig(project *types.Project, service types.ServiceConfig) (*container.Config, *container.HostConfig, *network.NetworkingConfig, error) {
 	envVars := []string{}
 	for key, val := range service.Environment {
 		envVars = append(envVars, fmt.Sprintf("%s=%s", key, *val))
 	}
+	// keep the order stable so identical services hash the same
+	sort.Strings(envVars)
+
+	// A container sharing another one's network namespace takes its
+	// hostname and ports from it, docker refuses them on the container.
+	networkMode := serviceRefToContainer(service.NetworkMode)
+	sharesNetwork := strings.HasPrefix(networkMode, types.ContainerPrefix)
+	if sharesNetwork {
+		switch {
+		case service.Hostname != "":
+			return nil, nil, nil, fmt.Errorf("service %s: hostname can't be set with network_mode %s", service.Name, service.NetworkMode)
+		case service.DomainName != "":
+			return nil, nil, nil, fmt.Errorf("service %s: domainname can't be set with network_mode %s", service.Name, service.NetworkMode)
+		case len(service.Ports) > 0:
+			return nil, nil, nil, fmt.Errorf("service %s: ports can't be published with network_mode %s", service.Name, service.NetworkMode)
+		case len(service.DNS) > 0 || len(service.DNSSearch) > 0 || len(service.DNSOpts) > 0:
+			return nil, nil, nil, fmt.Errorf("service %s: dns settings can't be set with network_mode %s", service.Name, service.NetworkMode)
+		case len(service.ExtraHosts) > 0:
+			return nil, nil, nil, fmt.Errorf("service %s: extra_hosts can't be set with network_mode %s", service.Name, service.NetworkMode)
+		case len(service.Links) > 0 || len(service.ExternalLinks) > 0:
+			return nil, nil, nil, fmt.Errorf("service %s: links can't be set with network_mode %s", service.Name, service.NetworkMode)
+		}
+	}
+	// Links need a network to resolve on, the host's and none have none.
+	if (networkMode == "host" || networkMode == "none") && (len(service.Links) > 0 || len(service.ExternalLinks) > 0) {
+		return nil, nil, nil, fmt.Errorf("service %s: links can't be set with network_mode %s", service.Name, service.NetworkMode)
+	}
+
+	// The container is reachable under its service name, so that's the
+	// hostname unless one is set explicitly.
+	hostname := service.Name
+	if service.Hostname != "" {
+		hostname = service.Hostname
+	}
+	if sharesNetwork {
+		hostname = ""
+	}
 
 	config := &container.Config{
-		Image:    service.Image,
-		Env:      envVars,
-		Cmd:      strslice.StrSlice(service.Command),
-		Labels:   service.Labels,
-		Hostname: service.Name,
+		Image:      service.Image,
+		Env:        envVars,
+		Cmd:        strslice.StrSlice(service.Command),
+		Entrypoint: translateEntrypoint(service.Entrypoint),
+		Labels:     service.Labels,
+		Hostname:   hostname,
+		Domainname: service.DomainName,
+		User:       service.User,
+		WorkingDir: service.WorkingDir,
+		Tty:        service.Tty,
+		OpenStdin:  service.StdinOpen,
+		StopSignal: service.StopSignal,
+	}
+	if service.StopGracePeriod != nil {
+		secs := StopSeconds(time.Duration(*service.StopGracePeriod))
+		config.StopTimeout = &secs
 	}
 
 	hostConfig := &container.HostConfig{
 		RestartPolicy: container.RestartPolicy{
 			Name: container.RestartPolicyMode(service.Restart),
 		},
-		Binds: []string{},
+		VolumeDriver:   service.VolumeDriver,
+		GroupAdd:       service.GroupAdd,
+		Init:           service.Init,
+		CapAdd:         service.CapAdd,
+		CapDrop:        service.CapDrop,
+		Privileged:     service.Privileged,
+		SecurityOpt:    service.SecurityOpt,
+		ReadonlyRootfs: service.ReadOnly,
+		UsernsMode:     container.UsernsMode(service.UserNSMode),
+		CgroupnsMode:   container.CgroupnsMode(service.Cgroup),
+		PidMode:        container.PidMode(serviceRefToContainer(service.Pid)),
+		IpcMode:        container.IpcMode(serviceRefToContainer(service.Ipc)),
+		UTSMode:        container.UTSMode(service.Uts),
+		ShmSize:        int64(service.ShmSize),
+		Sysctls:        service.Sysctls,
+		OomScoreAdj:    int(service.OomScoreAdj),
+		ExtraHosts:     TranslateExtraHosts(service.ExtraHosts),
+		DNS:            service.DNS,
+		DNSSearch:      service.DNSSearch,
+		DNSOptions:     service.DNSOpts,
+	}
+	resources, err := translateResources(service)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	hostConfig.Resources = resources
+	hostConfig.CgroupParent = service.CgroupParent
+	if service.OomKillDisable {
+		hostConfig.OomKillDisable = &service.OomKillDisable
 	}
 
-	for _, vol := range service.Volumes {
-		if vol.Source != "" && vol.Target != "" {
-			hostConfig.Binds = append(hostConfig.Binds, fmt.Sprintf("%s:%s", vol.Source, vol.Target))
+	binds, mounts, err := translateVolumes(project, service)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	hostConfig.Binds = binds
+	hostConfig.Mounts = mounts
+
+	if len(service.Tmpfs) > 0 {
+		hostConfig.Tmpfs = map[string]string{}
+		for _, t := range service.Tmpfs {
+			path, opts, _ := strings.Cut(t, ":")
+			hostConfig.Tmpfs[path] = opts
 		}
 	}
 
@@ -202,11 +592,33 @@ func TranslateServiceConfigToContainerConfig(service types.ServiceConfig) (*cont
 
 	config.ExposedPorts = exposedPorts
 	hostConfig.PortBindings = portBindings
-	networkConfig := &network.NetworkingConfig{}
+
+	networkConfig, err := translateNetworks(project, service)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	// Without a network of its own, e.g. with network_mode: bridge, the
+	// container gets legacy links instead of the endpoint ones.
+	if len(service.Networks) == 0 {
+		hostConfig.Links = translateLinks(service)
+	}
+	if networkMode != "" {
+		hostConfig.NetworkMode = container.NetworkMode(networkMode)
+	} else if names := ServiceNetworkNames(project, service); len(names) > 0 {
+		hostConfig.NetworkMode = container.NetworkMode(names[0])
+	}
 
 	return config, hostConfig, networkConfig, nil
 }
 
+// OptionalDependency reports whether the compose file set `required: false`
+// on the dependency. Up skips optional dependencies on services that aren't
+// in the project, any other missing dependency is an error.
+func OptionalDependency(dep types.ServiceDependency) bool {
+	optional, _ := dep.Extensions[OptionalDependencyExtension].(bool)
+	return optional && !dep.Required
+}
+
 func topoSortServices(services []types.ServiceConfig) ([]types.ServiceConfig, error) {
 	graph := map[string][]string{}
 	inDegree := map[string]int{}
@@ -218,7 +630,27 @@ func topoSortServices(services []types.ServiceConfig) ([]types.ServiceConfig, er
 	}
 
 	for _, svc := range services {
-		for dep := range svc.DependsOn {
+		deps := map[string]bool{}
+		for dep, cfg := range svc.DependsOn {
+			if _, ok := serviceMap[dep]; !ok {
+				// required: false dependencies on services that aren't in
+				// the project are skipped.
+				if OptionalDependency(cfg) {
+					continue
+				}
+				return nil, fmt.Errorf("service %s depends on undefined service %s", svc.Name, dep)
+			}
+			deps[dep] = true
+		}
+		// The loader adds these to depends_on, but the project may have been
+		// changed since.
+		for _, dep := range NamespaceDependencies(svc) {
+			if _, ok := serviceMap[dep]; !ok {
+				return nil, fmt.Errorf("service %s shares a namespace with undefined service %s", svc.Name, dep)
+			}
+			deps[dep] = true
+		}
+		for dep := range deps {
 			graph[dep] = append(graph[dep], svc.Name)
 			inDegree[svc.Name]++
 		}
@@ -254,3 +686,19 @@ func topoSortServices(services []types.ServiceConfig) ([]types.ServiceConfig, er
 
 	return result, nil
 }
+
+// StopSeconds converts a stop grace period into the whole seconds docker
+// takes, rounding up so that a sub-second period doesn't become an immediate
+// kill.
+func StopSeconds(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int((d + time.Second - 1) / time.Second)
+}
+
+// OrderServices returns the services in dependency order, dependencies first.
+// depends_on keys must refer to the names of the services in the slice.
+func OrderServices(services []types.ServiceConfig) ([]types.ServiceConfig, error) {
+	return topoSortServices(services)
+}
@@ -0,0 +1,91 @@
+package composeconvert
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/compose-spec/compose-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// loadProject writes the compose file to a temp dir and loads it with opts.
+func loadProject(t *testing.T, composeYML string, opts LoadComposeProjectOptions) (*types.Project, error) {
+	t.Helper()
+	opts.DockerComposePath = filepath.Join(t.TempDir(), "docker-compose.yml")
+	require.NoError(t, os.WriteFile(opts.DockerComposePath, []byte(composeYML), 0644))
+	return LoadComposeStack(t.Context(), opts)
+}
+
+func TestLoadBuildShmSize(t *testing.T) {
+	tests := []struct {
+		name      string
+		shmSize   string
+		expect    int64
+		expectErr string
+	}{
+		{name: "Units", shmSize: "256m", expect: 256 << 20},
+		{name: "Bytes", shmSize: "1048576", expect: 1 << 20},
+		{name: "Whole_float", shmSize: "2097152.0", expect: 2 << 20},
+		{name: "Interpolated", shmSize: "${SHM}", expect: 1 << 30},
+		{name: "Interpolated_default", shmSize: "${MISSING:-64m}", expect: 64 << 20},
+		{name: "Invalid", shmSize: "lots", expectErr: `invalid build.shm_size "lots" for service app`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			project, err := loadProject(t, `
+services:
+  app:
+    build:
+      context: .
+      shm_size: `+tt.shmSize+`
+`, LoadComposeProjectOptions{Env: map[string]string{"SHM": "1g"}})
+			if tt.expectErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expect, project.Services[0].Build.Extensions[BuildShmSizeExtension])
+		})
+	}
+}
+
+func TestStopSeconds(t *testing.T) {
+	assert.Equal(t, 0, StopSeconds(0))
+	assert.Equal(t, 1, StopSeconds(500*time.Millisecond))
+	assert.Equal(t, 2, StopSeconds(1500*time.Millisecond))
+	assert.Equal(t, 10, StopSeconds(10*time.Second))
+}
+
+func TestOrderServices_MissingDependencies(t *testing.T) {
+	project, err := loadProject(t, `
+services:
+  app:
+    image: alpine
+    depends_on:
+      cache:
+        condition: service_started
+        required: false
+  cache:
+    image: redis
+`, LoadComposeProjectOptions{})
+	require.NoError(t, err)
+	app, err := project.GetService("app")
+	require.NoError(t, err)
+	assert.True(t, OptionalDependency(app.DependsOn["cache"]))
+
+	// The dependency loaded with required: false is skipped once cache is
+	// gone, the same built by hand is still required.
+	ordered, err := OrderServices([]types.ServiceConfig{app})
+	require.NoError(t, err)
+	assert.Len(t, ordered, 1)
+
+	app.DependsOn = types.DependsOnConfig{"cache": {Condition: types.ServiceConditionStarted}}
+	_, err = OrderServices([]types.ServiceConfig{app})
+	require.EqualError(t, err, "service app depends on undefined service cache")
+}
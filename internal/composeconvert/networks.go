@@ -0,0 +1,96 @@
+package composeconvert
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/compose-spec/compose-go/types"
+	"github.com/docker/docker/api/types/network"
+)
+
+// ServiceNetworkNames returns the docker names of the networks the service is
+// attached to, highest priority first. The first one is the network the
+// container gets created on, the rest are connected afterwards.
+func ServiceNetworkNames(project *types.Project, service types.ServiceConfig) []string {
+	keys := make([]string, 0, len(service.Networks))
+	for key := range service.Networks {
+		keys = append(keys, key)
+	}
+	priority := func(key string) int {
+		if cfg := service.Networks[key]; cfg != nil {
+			return cfg.Priority
+		}
+		return 0
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if priority(keys[i]) != priority(keys[j]) {
+			return priority(keys[i]) > priority(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+
+	names := make([]string, 0, len(keys))
+	for _, key := range keys {
+		names = append(names, networkName(project, key))
+	}
+	return names
+}
+
+// networkName maps a key of the top-level networks section to the name of the
+// docker network.
+func networkName(project *types.Project, key string) string {
+	if nw, ok := project.Networks[key]; ok && nw.Name != "" {
+		return nw.Name
+	}
+	return key
+}
+
+// translateNetworks returns the service's endpoint on each of its networks.
+// Links and external links are set as the links of every endpoint, which
+// docker resolves as aliases of the linked containers that only this
+// container sees, not as aliases other containers can reach it under.
+func translateNetworks(project *types.Project, service types.ServiceConfig) (*network.NetworkingConfig, error) {
+	links := translateLinks(service)
+	endpoints := map[string]*network.EndpointSettings{}
+	for key, cfg := range service.Networks {
+		if _, ok := project.Networks[key]; !ok {
+			return nil, fmt.Errorf("service %s refers to undefined network %s", service.Name, key)
+		}
+
+		endpoint := &network.EndpointSettings{
+			Aliases: []string{OriginalServiceName(service)},
+			Links:   links,
+		}
+		if cfg != nil {
+			endpoint.Aliases = append(endpoint.Aliases, cfg.Aliases...)
+			endpoint.MacAddress = cfg.MacAddress
+			if cfg.Ipv4Address != "" || cfg.Ipv6Address != "" || len(cfg.LinkLocalIPs) > 0 {
+				endpoint.IPAMConfig = &network.EndpointIPAMConfig{
+					IPv4Address:  cfg.Ipv4Address,
+					IPv6Address:  cfg.Ipv6Address,
+					LinkLocalIPs: cfg.LinkLocalIPs,
+				}
+			}
+		}
+		endpoints[networkName(project, key)] = endpoint
+	}
+
+	return &network.NetworkingConfig{EndpointsConfig: endpoints}, nil
+}
+
+// translateLinks renders links and external_links as the `container:alias`
+// entries docker expects. Links already name the renamed service, external
+// links are containers outside the project and are left as they are.
+func translateLinks(service types.ServiceConfig) []string {
+	var links []string
+	for _, link := range append(slices.Clone(service.Links), service.ExternalLinks...) {
+		name, alias, ok := strings.Cut(link, ":")
+		if !ok {
+			alias = name
+		}
+		links = append(links, name+":"+alias)
+	}
+	return links
+}
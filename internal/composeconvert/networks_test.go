@@ -0,0 +1,139 @@
+package composeconvert
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTranslateHostResolution(t *testing.T) {
+	tests := []struct {
+		name       string
+		composeYML string
+		expectErr  string
+		assertFunc func(t *testing.T, hostConfig *container.HostConfig, netConfig *network.NetworkingConfig)
+	}{
+		{
+			name: "DNS_and_extra_hosts",
+			composeYML: `
+services:
+  app:
+    image: alpine:latest
+    dns: [1.1.1.1, 8.8.8.8]
+    dns_search: example.test
+    dns_opt: [use-vc]
+    extra_hosts:
+      - host.docker.internal:host-gateway
+      - db.local:10.0.0.5
+`,
+			assertFunc: func(t *testing.T, hostConfig *container.HostConfig, netConfig *network.NetworkingConfig) {
+				assert.Equal(t, []string{"1.1.1.1", "8.8.8.8"}, hostConfig.DNS)
+				assert.Equal(t, []string{"example.test"}, hostConfig.DNSSearch)
+				assert.Equal(t, []string{"use-vc"}, hostConfig.DNSOptions)
+				assert.Equal(t, []string{"db.local:10.0.0.5", "host.docker.internal:host-gateway"}, hostConfig.ExtraHosts)
+			},
+		},
+		{
+			name: "Links_use_renamed_services",
+			composeYML: `
+services:
+  app:
+    image: alpine:latest
+    links:
+      - db
+      - cache:redis
+    external_links:
+      - legacy
+      - other-project-api:api
+    networks: [default, backend]
+  db:
+    image: alpine:latest
+    networks: [default, backend]
+  cache:
+    image: alpine:latest
+    networks: [default, backend]
+networks:
+  backend:
+`,
+			assertFunc: func(t *testing.T, hostConfig *container.HostConfig, netConfig *network.NetworkingConfig) {
+				want := []string{"p-db-s:db", "p-cache-s:redis", "legacy:legacy", "other-project-api:api"}
+				require.Len(t, netConfig.EndpointsConfig, 2)
+				for name, endpoint := range netConfig.EndpointsConfig {
+					assert.Equal(t, want, endpoint.Links, "links on %s", name)
+				}
+				assert.Empty(t, hostConfig.Links)
+			},
+		},
+		{
+			name: "Links_without_service_networks",
+			composeYML: `
+services:
+  app:
+    image: alpine:latest
+    network_mode: bridge
+    links:
+      - db:database
+    external_links:
+      - legacy
+  db:
+    image: alpine:latest
+    network_mode: bridge
+`,
+			assertFunc: func(t *testing.T, hostConfig *container.HostConfig, netConfig *network.NetworkingConfig) {
+				assert.Equal(t, container.NetworkMode("bridge"), hostConfig.NetworkMode)
+				assert.Equal(t, []string{"p-db-s:database", "legacy:legacy"}, hostConfig.Links)
+				assert.Empty(t, netConfig.EndpointsConfig)
+			},
+		},
+		{
+			name: "Links_with_host_network",
+			composeYML: `
+services:
+  app:
+    image: alpine:latest
+    network_mode: host
+    external_links:
+      - legacy
+`,
+			expectErr: "service p-app-s: links can't be set with network_mode host",
+		},
+		{
+			name: "DNS_with_shared_network_namespace",
+			composeYML: `
+services:
+  app:
+    image: alpine:latest
+    network_mode: service:vpn
+    dns: [1.1.1.1]
+  vpn:
+    image: alpine:latest
+`,
+			expectErr: "service p-app-s: dns settings can't be set with network_mode service:p-vpn-s",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			project, err := loadProject(t, tt.composeYML, LoadComposeProjectOptions{
+				NamePrefix: "p-",
+				NameSuffix: "-s",
+			})
+			require.NoError(t, err)
+			service, err := project.GetService("p-app-s")
+			require.NoError(t, err)
+
+			_, hostConfig, netConfig, err := TranslateServiceConfigToContainerConfig(project, service)
+			if tt.expectErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectErr)
+				return
+			}
+			require.NoError(t, err)
+			tt.assertFunc(t, hostConfig, netConfig)
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package composeconvert
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/compose-spec/compose-go/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-units"
+)
+
+// translateResources maps the service's resource keys onto container
+// resources. Limits and reservations can be given as top-level keys
+// (mem_limit, cpus, pids_limit, mem_reservation) or under deploy.resources,
+// both may be used as long as they agree. CPU reservations only mean
+// something to swarm and are ignored.
+func translateResources(service types.ServiceConfig) (container.Resources, error) {
+	res := container.Resources{
+		CPUShares:  service.CPUShares,
+		CPUQuota:   service.CPUQuota,
+		CPUPeriod:  service.CPUPeriod,
+		CpusetCpus: service.CPUSet,
+		MemorySwap: int64(service.MemSwapLimit),
+		Ulimits:    TranslateUlimits(service.Ulimits),
+	}
+	if service.MemSwappiness != 0 {
+		swappiness := int64(service.MemSwappiness)
+		res.MemorySwappiness = &swappiness
+	}
+
+	var limits, reservations types.Resource
+	if service.Deploy != nil {
+		if service.Deploy.Resources.Limits != nil {
+			limits = *service.Deploy.Resources.Limits
+		}
+		if service.Deploy.Resources.Reservations != nil {
+			reservations = *service.Deploy.Resources.Reservations
+		}
+	}
+
+	var err error
+	if res.Memory, err = mergeBytes(service.Name, "mem_limit", service.MemLimit, "deploy.resources.limits.memory", limits.MemoryBytes); err != nil {
+		return res, err
+	}
+	if res.MemoryReservation, err = mergeBytes(service.Name, "mem_reservation", service.MemReservation, "deploy.resources.reservations.memory", reservations.MemoryBytes); err != nil {
+		return res, err
+	}
+
+	if service.CPUS != 0 || limits.NanoCPUs != "" {
+		var top, deploy int64
+		if service.CPUS != 0 {
+			top, _ = nanoCPUs(strconv.FormatFloat(float64(service.CPUS), 'f', -1, 32))
+		}
+		if limits.NanoCPUs != "" {
+			if deploy, err = nanoCPUs(limits.NanoCPUs); err != nil {
+				return res, fmt.Errorf("service %s: invalid deploy.resources.limits.cpus %q: %w", service.Name, limits.NanoCPUs, err)
+			}
+		}
+		if top != 0 && deploy != 0 && top != deploy {
+			return res, fmt.Errorf("service %s: cpus %v conflicts with deploy.resources.limits.cpus %s", service.Name, service.CPUS, limits.NanoCPUs)
+		}
+		res.NanoCPUs = max(top, deploy)
+	}
+
+	if service.PidsLimit != 0 || limits.Pids != 0 {
+		if service.PidsLimit != 0 && limits.Pids != 0 && service.PidsLimit != limits.Pids {
+			return res, fmt.Errorf("service %s: pids_limit %d conflicts with deploy.resources.limits.pids %d", service.Name, service.PidsLimit, limits.Pids)
+		}
+		pids := service.PidsLimit
+		if pids == 0 {
+			pids = limits.Pids
+		}
+		res.PidsLimit = &pids
+	}
+
+	for _, device := range reservations.Devices {
+		res.DeviceRequests = append(res.DeviceRequests, container.DeviceRequest{
+			Driver:       device.Driver,
+			Count:        int(device.Count),
+			DeviceIDs:    device.IDs,
+			Capabilities: [][]string{device.Capabilities},
+		})
+	}
+	return res, nil
+}
+
+// mergeBytes returns whichever of the two byte sizes is set, or an error
+// when both are and they differ.
+func mergeBytes(service, topKey string, top types.UnitBytes, deployKey string, deploy types.UnitBytes) (int64, error) {
+	if top != 0 && deploy != 0 && top != deploy {
+		return 0, fmt.Errorf("service %s: %s %s conflicts with %s %s", service,
+			topKey, units.BytesSize(float64(top)), deployKey, units.BytesSize(float64(deploy)))
+	}
+	if top != 0 {
+		return int64(top), nil
+	}
+	return int64(deploy), nil
+}
+
+// nanoCPUs converts a number of CPUs like "1.5" into billionths of a CPU.
+func nanoCPUs(cpus string) (int64, error) {
+	f, err := strconv.ParseFloat(cpus, 64)
+	if err != nil {
+		return 0, err
+	}
+	return int64(math.Round(f * 1e9)), nil
+}
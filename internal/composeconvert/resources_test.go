@@ -0,0 +1,167 @@
+package composeconvert
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTranslateResources(t *testing.T) {
+	tests := []struct {
+		name       string
+		composeYML string
+		expectErr  string
+		assertFunc func(t *testing.T, res container.Resources)
+	}{
+		{
+			name: "Top_level_keys",
+			composeYML: `
+services:
+  app:
+    image: alpine:latest
+    mem_limit: 256m
+    mem_reservation: 128m
+    memswap_limit: 512m
+    cpus: 1.1
+    cpu_shares: 512
+    pids_limit: 100
+    ulimits:
+      nofile:
+        soft: 1024
+        hard: 2048
+`,
+			assertFunc: func(t *testing.T, res container.Resources) {
+				assert.Equal(t, int64(256<<20), res.Memory)
+				assert.Equal(t, int64(128<<20), res.MemoryReservation)
+				assert.Equal(t, int64(512<<20), res.MemorySwap)
+				assert.Equal(t, int64(1_100_000_000), res.NanoCPUs)
+				assert.Equal(t, int64(512), res.CPUShares)
+				require.NotNil(t, res.PidsLimit)
+				assert.Equal(t, int64(100), *res.PidsLimit)
+				assert.Equal(t, []*container.Ulimit{{Name: "nofile", Soft: 1024, Hard: 2048}}, res.Ulimits)
+			},
+		},
+		{
+			name: "Deploy_resources",
+			composeYML: `
+services:
+  app:
+    image: alpine:latest
+    deploy:
+      resources:
+        limits:
+          cpus: "0.5"
+          memory: 1g
+          pids: 50
+        reservations:
+          memory: 64m
+          devices:
+            - driver: nvidia
+              count: 1
+              capabilities: [gpu]
+`,
+			assertFunc: func(t *testing.T, res container.Resources) {
+				assert.Equal(t, int64(1<<30), res.Memory)
+				assert.Equal(t, int64(64<<20), res.MemoryReservation)
+				assert.Equal(t, int64(500_000_000), res.NanoCPUs)
+				require.NotNil(t, res.PidsLimit)
+				assert.Equal(t, int64(50), *res.PidsLimit)
+				assert.Equal(t, []container.DeviceRequest{{Driver: "nvidia", Count: 1, Capabilities: [][]string{{"gpu"}}}}, res.DeviceRequests)
+			},
+		},
+		{
+			name: "Both_syntaxes_agreeing",
+			composeYML: `
+services:
+  app:
+    image: alpine:latest
+    mem_limit: 1g
+    cpus: 1.1
+    deploy:
+      resources:
+        limits:
+          cpus: "1.1"
+          memory: 1024m
+`,
+			assertFunc: func(t *testing.T, res container.Resources) {
+				assert.Equal(t, int64(1<<30), res.Memory)
+				assert.Equal(t, int64(1_100_000_000), res.NanoCPUs)
+			},
+		},
+		{
+			name: "Conflicting_memory",
+			composeYML: `
+services:
+  app:
+    image: alpine:latest
+    mem_limit: 512m
+    deploy:
+      resources:
+        limits:
+          memory: 1g
+`,
+			expectErr: "service app: mem_limit 512MiB conflicts with deploy.resources.limits.memory 1GiB",
+		},
+		{
+			name: "Conflicting_cpus",
+			composeYML: `
+services:
+  app:
+    image: alpine:latest
+    cpus: 2
+    deploy:
+      resources:
+        limits:
+          cpus: "0.5"
+`,
+			expectErr: "service app: cpus 2 conflicts with deploy.resources.limits.cpus 0.5",
+		},
+		{
+			name: "Conflicting_pids",
+			composeYML: `
+services:
+  app:
+    image: alpine:latest
+    pids_limit: 10
+    deploy:
+      resources:
+        limits:
+          pids: 20
+`,
+			expectErr: "service app: pids_limit 10 conflicts with deploy.resources.limits.pids 20",
+		},
+		{
+			name: "Conflicting_reservation",
+			composeYML: `
+services:
+  app:
+    image: alpine:latest
+    mem_reservation: 64m
+    deploy:
+      resources:
+        reservations:
+          memory: 32m
+`,
+			expectErr: "mem_reservation 64MiB conflicts with deploy.resources.reservations.memory 32MiB",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			project, err := loadProject(t, tt.composeYML, LoadComposeProjectOptions{})
+			if tt.expectErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectErr)
+				return
+			}
+			require.NoError(t, err)
+
+			_, hostConfig, _, err := TranslateServiceConfigToContainerConfig(project, project.Services[0])
+			require.NoError(t, err)
+			tt.assertFunc(t, hostConfig.Resources)
+		})
+	}
+}
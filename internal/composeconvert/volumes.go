@@ -0,0 +1,128 @@
+package composeconvert
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/compose-spec/compose-go/types"
+	"github.com/docker/docker/api/types/mount"
+)
+
+// translateVolumes splits the service volumes into legacy binds and mounts.
+// Bind mounts that should create a missing host path (the short syntax and
+// `bind.create_host_path: true`) go through Binds, as the mounts API refuses
+// sources that don't exist. Everything else becomes a mount.Mount.
+func translateVolumes(project *types.Project, service types.ServiceConfig) ([]string, []mount.Mount, error) {
+	binds := []string{}
+	mounts := []mount.Mount{}
+
+	for _, vol := range service.Volumes {
+		if vol.Target == "" {
+			return nil, nil, fmt.Errorf("volume %q of service %s has no target", vol.Source, service.Name)
+		}
+
+		switch vol.Type {
+		case types.VolumeTypeBind:
+			if vol.Source == "" {
+				return nil, nil, fmt.Errorf("bind mount %s of service %s has no source", vol.Target, service.Name)
+			}
+			vol.Source = ResolveProjectPath(project, vol.Source)
+			if vol.Bind != nil && vol.Bind.CreateHostPath {
+				binds = append(binds, bindString(vol))
+				continue
+			}
+			m := mount.Mount{
+				Type:        mount.TypeBind,
+				Source:      vol.Source,
+				Target:      vol.Target,
+				ReadOnly:    vol.ReadOnly,
+				Consistency: mount.Consistency(vol.Consistency),
+			}
+			if vol.Bind != nil && vol.Bind.Propagation != "" {
+				m.BindOptions = &mount.BindOptions{Propagation: mount.Propagation(vol.Bind.Propagation)}
+			}
+			mounts = append(mounts, m)
+
+		case types.VolumeTypeVolume, "":
+			m := mount.Mount{
+				Type:        mount.TypeVolume,
+				Source:      volumeName(project, vol.Source),
+				Target:      vol.Target,
+				ReadOnly:    vol.ReadOnly,
+				Consistency: mount.Consistency(vol.Consistency),
+			}
+			if vol.Volume != nil && vol.Volume.NoCopy {
+				m.VolumeOptions = &mount.VolumeOptions{NoCopy: true}
+			}
+			mounts = append(mounts, m)
+
+		case types.VolumeTypeTmpfs:
+			m := mount.Mount{
+				Type:     mount.TypeTmpfs,
+				Target:   vol.Target,
+				ReadOnly: vol.ReadOnly,
+			}
+			if vol.Tmpfs != nil {
+				m.TmpfsOptions = &mount.TmpfsOptions{
+					SizeBytes: int64(vol.Tmpfs.Size),
+					Mode:      os.FileMode(vol.Tmpfs.Mode),
+				}
+			}
+			mounts = append(mounts, m)
+
+		case types.VolumeTypeNamedPipe:
+			mounts = append(mounts, mount.Mount{
+				Type:     mount.TypeNamedPipe,
+				Source:   vol.Source,
+				Target:   vol.Target,
+				ReadOnly: vol.ReadOnly,
+			})
+
+		default:
+			return nil, nil, fmt.Errorf("unsupported volume type %q for %s in service %s", vol.Type, vol.Target, service.Name)
+		}
+	}
+
+	return binds, mounts, nil
+}
+
+// bindString renders a bind mount in the `source:target[:options]` form.
+func bindString(vol types.ServiceVolumeConfig) string {
+	opts := []string{"rw"}
+	if vol.ReadOnly {
+		opts[0] = "ro"
+	}
+	if vol.Bind != nil {
+		if vol.Bind.Propagation != "" {
+			opts = append(opts, vol.Bind.Propagation)
+		}
+		if vol.Bind.SELinux != "" {
+			opts = append(opts, vol.Bind.SELinux)
+		}
+	}
+	if vol.Consistency != "" && vol.Consistency != "default" {
+		opts = append(opts, vol.Consistency)
+	}
+	return fmt.Sprintf("%s:%s:%s", vol.Source, vol.Target, strings.Join(opts, ","))
+}
+
+// volumeName maps a key of the top-level volumes section to the name of the
+// docker volume. Anonymous volumes keep an empty source.
+func volumeName(project *types.Project, source string) string {
+	if v, ok := project.Volumes[source]; ok && v.Name != "" {
+		return v.Name
+	}
+	return source
+}
+
+// ResolveProjectPath makes a relative path absolute against the project's
+// working directory. The loader already does this for projects it loads, this
+// covers projects built or modified by hand.
+func ResolveProjectPath(project *types.Project, path string) string {
+	if path == "" || filepath.IsAbs(path) || project.WorkingDir == "" {
+		return path
+	}
+	return filepath.Join(project.WorkingDir, path)
+}
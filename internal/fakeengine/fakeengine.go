@@ -0,0 +1,844 @@
+// Package fakeengine is an in-memory stand-in for the Docker Engine API, so
+// the runner's ordering and failure paths can be tested without a daemon.
+//
+// Containers move through the same states as real ones. Their health and exit
+// codes follow the Behavior registered for their name, health advancing one
+// step every HealthInterval. Start, die and health_status changes are
+// published on the Events stream like the daemon does.
+package fakeengine
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/build"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Behavior scripts what a container does once it's started.
+type Behavior struct {
+	// Health is the sequence of health statuses the container goes through
+	// after it started, one every HealthInterval. The last one sticks. When
+	// empty a container with a healthcheck goes from starting to healthy.
+	Health []container.HealthStatus
+	// HealthInterval defaults to 10ms.
+	HealthInterval time.Duration
+	// HealthOutput is the output of every health check, which exits 0 for a
+	// healthy step and 1 otherwise.
+	HealthOutput string
+	// Exit makes the container exit with ExitCode as soon as it starts.
+	Exit     bool
+	ExitCode int
+	// OOMKilled marks an exited container as killed by the OOM killer.
+	OOMKilled bool
+	// StartErr is returned by ContainerStart instead of starting.
+	StartErr error
+	// Logs is the container's stdout as returned by ContainerLogs.
+	Logs string
+}
+
+// Build is the fake's record of an image build.
+type Build struct {
+	Options build.ImageBuildOptions
+	// Files holds the regular files of the build context by path.
+	Files map[string]string
+}
+
+// Container is the fake's record of a created container.
+type Container struct {
+	ID         string
+	Name       string
+	Config     *container.Config
+	HostConfig *container.HostConfig
+	Networks   map[string]*network.EndpointSettings
+	Running    bool
+	Started    bool
+	ExitCode   int
+	OOMKilled  bool
+	StartedAt  time.Time
+	FinishedAt time.Time
+	health     container.HealthStatus
+	healthLog  []*container.HealthcheckResult
+	// generation changes on every start, so a health goroutine of an earlier
+	// run stops.
+	generation int
+}
+
+// Engine implements runner.Engine in memory. The zero value isn't usable, use
+// New.
+type Engine struct {
+	mu         sync.Mutex
+	nextID     int
+	containers map[string]*Container // by ID
+	images     map[string]fakeImage  // by reference
+	networks   map[string]network.Inspect
+	volumes    map[string]volume.Volume
+	behaviors  map[string]Behavior
+	pullErrs   map[string]error
+	calls      []string
+	builds     map[string]Build // by tag
+	// history holds every published event, subscribers get the ones since
+	// their Since replayed.
+	history     []events.Message
+	subscribers map[*subscriber]struct{}
+}
+
+// fakeImage is a locally available image. Pulling or building it again keeps
+// its ID, UpdateImage gives it a new one.
+type fakeImage struct {
+	id     string
+	tagged time.Time
+}
+
+type subscriber struct {
+	filters filters.Args
+	msgs    chan events.Message
+	errs    chan error
+}
+
+// send never blocks, as it's called with the engine locked. The buffer is
+// large enough that a test never loses events.
+func (s *subscriber) send(msg events.Message) {
+	select {
+	case s.msgs <- msg:
+	default:
+	}
+}
+
+func New() *Engine {
+	return &Engine{
+		containers:  map[string]*Container{},
+		images:      map[string]fakeImage{},
+		networks:    map[string]network.Inspect{},
+		volumes:     map[string]volume.Volume{},
+		behaviors:   map[string]Behavior{},
+		pullErrs:    map[string]error{},
+		builds:      map[string]Build{},
+		subscribers: map[*subscriber]struct{}{},
+	}
+}
+
+// SetBehavior scripts the container that will be created with the given name.
+func (e *Engine) SetBehavior(containerName string, b Behavior) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.behaviors[containerName] = b
+}
+
+// AddImage makes an image available locally, as if it had been pulled.
+func (e *Engine) AddImage(ref string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.tagImage(ref)
+}
+
+// UpdateImage replaces the local image with a new one under the same
+// reference, as if a newer version had been pulled or it had been rebuilt.
+func (e *Engine) UpdateImage(ref string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.images, ref)
+	e.tagImage(ref)
+}
+
+// tagImage stamps the reference as just tagged, keeping the ID of an image
+// already under it.
+func (e *Engine) tagImage(ref string) {
+	img, ok := e.images[ref]
+	if !ok {
+		e.nextID++
+		img.id = fmt.Sprintf("sha256:%064x", e.nextID)
+	}
+	img.tagged = time.Now()
+	e.images[ref] = img
+}
+
+// HasImage reports whether the image is available locally.
+func (e *Engine) HasImage(ref string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, ok := e.images[ref]
+	return ok
+}
+
+// LastBuild returns the last build that tagged the image.
+func (e *Engine) LastBuild(tag string) (Build, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	b, ok := e.builds[tag]
+	return b, ok
+}
+
+// SetPullError makes pulls of ref fail with err.
+func (e *Engine) SetPullError(ref string, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.pullErrs[ref] = err
+}
+
+// Calls returns the calls made so far as "<Method> <name>", in order.
+func (e *Engine) Calls() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]string(nil), e.calls...)
+}
+
+// Container returns a copy of the container with the given name or ID.
+func (e *Engine) Container(nameOrID string) (Container, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	c, err := e.lookup(nameOrID)
+	if err != nil {
+		return Container{}, false
+	}
+	return *c, true
+}
+
+func (e *Engine) record(method, name string) {
+	e.calls = append(e.calls, method+" "+name)
+}
+
+func (e *Engine) lookup(nameOrID string) (*Container, error) {
+	nameOrID = strings.TrimPrefix(nameOrID, "/")
+	for _, c := range e.containers {
+		if c.ID == nameOrID || c.Name == nameOrID {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("No such container: %s: %w", nameOrID, cerrdefs.ErrNotFound)
+}
+
+func (e *Engine) newID() string {
+	e.nextID++
+	return fmt.Sprintf("%064x", e.nextID)
+}
+
+func (e *Engine) ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, platform *ocispec.Platform, containerName string) (container.CreateResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.record("ContainerCreate", containerName)
+
+	if _, err := e.lookup(containerName); err == nil {
+		return container.CreateResponse{}, fmt.Errorf("container name %s is already in use: %w", containerName, cerrdefs.ErrConflict)
+	}
+	if _, ok := e.images[config.Image]; !ok {
+		return container.CreateResponse{}, fmt.Errorf("No such image: %s: %w", config.Image, cerrdefs.ErrNotFound)
+	}
+
+	c := &Container{
+		ID:         e.newID(),
+		Name:       containerName,
+		Config:     config,
+		HostConfig: hostConfig,
+		Networks:   map[string]*network.EndpointSettings{},
+	}
+	if networkingConfig != nil {
+		for name, endpoint := range networkingConfig.EndpointsConfig {
+			c.Networks[name] = endpoint
+		}
+	}
+	e.containers[c.ID] = c
+	return container.CreateResponse{ID: c.ID}, nil
+}
+
+func (e *Engine) ContainerStart(ctx context.Context, containerID string, options container.StartOptions) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	c, err := e.lookup(containerID)
+	if err != nil {
+		return err
+	}
+	e.record("ContainerStart", c.Name)
+
+	b := e.behaviors[c.Name]
+	if b.StartErr != nil {
+		return b.StartErr
+	}
+	if c.Running {
+		return nil
+	}
+
+	c.Started = true
+	c.StartedAt = time.Now()
+	c.generation++
+	c.health = ""
+	c.healthLog = nil
+	e.publish(c, events.ActionStart, nil)
+
+	if b.Exit {
+		c.Running = false
+		c.ExitCode = b.ExitCode
+		c.OOMKilled = b.OOMKilled
+		c.FinishedAt = c.StartedAt
+		e.publish(c, events.ActionDie, map[string]string{"exitCode": strconv.Itoa(c.ExitCode)})
+		return nil
+	}
+	c.Running = true
+	c.ExitCode = 0
+	c.OOMKilled = false
+
+	steps := b.Health
+	if len(steps) == 0 && hasHealthcheck(c.Config) {
+		steps = []container.HealthStatus{container.Starting, container.Healthy}
+	}
+	if len(steps) > 0 {
+		interval := b.HealthInterval
+		if interval <= 0 {
+			interval = 10 * time.Millisecond
+		}
+		c.health = steps[0]
+		go e.runHealth(c, c.generation, steps[1:], interval)
+	}
+	return nil
+}
+
+// runHealth moves the container through its health steps until it stops or
+// is started again.
+func (e *Engine) runHealth(c *Container, generation int, steps []container.HealthStatus, interval time.Duration) {
+	for _, status := range steps {
+		time.Sleep(interval)
+		e.mu.Lock()
+		if !c.Running || c.generation != generation {
+			e.mu.Unlock()
+			return
+		}
+		c.logHealthCheck(status, e.behaviors[c.Name].HealthOutput)
+		if c.health != status {
+			c.health = status
+			e.publish(c, events.Action(string(events.ActionHealthStatus)+": "+string(status)), nil)
+		}
+		e.mu.Unlock()
+	}
+}
+
+// logHealthCheck records a health check result, keeping the last five like
+// the daemon does.
+func (c *Container) logHealthCheck(status container.HealthStatus, output string) {
+	now := time.Now()
+	exitCode := 1
+	if status == container.Healthy {
+		exitCode = 0
+	}
+	c.healthLog = append(c.healthLog, &container.HealthcheckResult{Start: now, End: now, ExitCode: exitCode, Output: output})
+	if len(c.healthLog) > 5 {
+		c.healthLog = c.healthLog[len(c.healthLog)-5:]
+	}
+}
+
+func hasHealthcheck(config *container.Config) bool {
+	return config != nil && config.Healthcheck != nil && len(config.Healthcheck.Test) > 0 && config.Healthcheck.Test[0] != "NONE"
+}
+
+func (e *Engine) ContainerStop(ctx context.Context, containerID string, options container.StopOptions) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	c, err := e.lookup(containerID)
+	if err != nil {
+		return err
+	}
+	e.record("ContainerStop", c.Name)
+	if c.Running {
+		c.Running = false
+		c.FinishedAt = time.Now()
+		e.publish(c, events.ActionDie, map[string]string{"exitCode": strconv.Itoa(c.ExitCode)})
+	}
+	return nil
+}
+
+func (e *Engine) ContainerRemove(ctx context.Context, containerID string, options container.RemoveOptions) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	c, err := e.lookup(containerID)
+	if err != nil {
+		return err
+	}
+	e.record("ContainerRemove", c.Name)
+	if c.Running && !options.Force {
+		return fmt.Errorf("cannot remove running container %s: %w", c.Name, cerrdefs.ErrConflict)
+	}
+	delete(e.containers, c.ID)
+	return nil
+}
+
+func (e *Engine) ContainerRename(ctx context.Context, containerID, newContainerName string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	c, err := e.lookup(containerID)
+	if err != nil {
+		return err
+	}
+	e.record("ContainerRename", c.Name)
+	if other, err := e.lookup(newContainerName); err == nil && other != c {
+		return fmt.Errorf("container name %s is already in use: %w", newContainerName, cerrdefs.ErrConflict)
+	}
+	c.Name = strings.TrimPrefix(newContainerName, "/")
+	return nil
+}
+
+func (e *Engine) ContainerInspect(ctx context.Context, containerID string) (container.InspectResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	c, err := e.lookup(containerID)
+	if err != nil {
+		return container.InspectResponse{}, err
+	}
+
+	e.record("ContainerInspect", c.Name)
+
+	state := &container.State{
+		Status:    c.state(),
+		Running:   c.Running,
+		ExitCode:  c.ExitCode,
+		OOMKilled: c.OOMKilled,
+	}
+	if c.Started {
+		state.StartedAt = c.StartedAt.Format(time.RFC3339Nano)
+	}
+	if !c.FinishedAt.IsZero() {
+		state.FinishedAt = c.FinishedAt.Format(time.RFC3339Nano)
+	}
+	if c.health != "" {
+		state.Health = &container.Health{Status: c.health, Log: slices.Clone(c.healthLog)}
+	}
+
+	networks := map[string]*network.EndpointSettings{}
+	for name, endpoint := range c.Networks {
+		networks[name] = endpoint
+	}
+	return container.InspectResponse{
+		ContainerJSONBase: &container.ContainerJSONBase{
+			ID:         c.ID,
+			Name:       "/" + c.Name,
+			Image:      c.Config.Image,
+			State:      state,
+			HostConfig: c.HostConfig,
+		},
+		Config:          c.Config,
+		NetworkSettings: &container.NetworkSettings{Networks: networks},
+	}, nil
+}
+
+func (c *Container) state() container.ContainerState {
+	switch {
+	case c.Running:
+		return container.StateRunning
+	case c.Started:
+		return container.StateExited
+	default:
+		return container.StateCreated
+	}
+}
+
+func (e *Engine) ContainerList(ctx context.Context, options container.ListOptions) ([]container.Summary, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var list []container.Summary
+	for _, c := range e.containers {
+		if !options.All && !c.Running {
+			continue
+		}
+		if !matchLabels(c.Config.Labels, options.Filters) {
+			continue
+		}
+		list = append(list, container.Summary{
+			ID:     c.ID,
+			Names:  []string{"/" + c.Name},
+			Image:  c.Config.Image,
+			Labels: c.Config.Labels,
+			State:  c.state(),
+			Status: string(c.state()),
+		})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Names[0] < list[j].Names[0] })
+	return list, nil
+}
+
+// matchLabels applies the label filters the runner uses, "key" or "key=value".
+func matchLabels(labels map[string]string, args filters.Args) bool {
+	for _, f := range args.Get("label") {
+		key, value, hasValue := strings.Cut(f, "=")
+		got, ok := labels[key]
+		if !ok || (hasValue && got != value) {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *Engine) ContainerLogs(ctx context.Context, containerID string, options container.LogsOptions) (io.ReadCloser, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	c, err := e.lookup(containerID)
+	if err != nil {
+		return nil, err
+	}
+	logs := e.behaviors[c.Name].Logs
+	if options.Tail != "" && options.Tail != "all" {
+		var n int
+		if _, err := fmt.Sscanf(options.Tail, "%d", &n); err == nil {
+			lines := strings.SplitAfter(strings.TrimSuffix(logs, "\n"), "\n")
+			if n < len(lines) {
+				lines = lines[len(lines)-n:]
+			}
+			logs = strings.Join(lines, "")
+			if logs != "" && !strings.HasSuffix(logs, "\n") {
+				logs += "\n"
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if c.Config.Tty {
+		buf.WriteString(logs)
+	} else if logs != "" {
+		_, _ = stdcopy.NewStdWriter(&buf, stdcopy.Stdout).Write([]byte(logs))
+	}
+	return io.NopCloser(&buf), nil
+}
+
+func (e *Engine) ContainerExecCreate(ctx context.Context, containerID string, options container.ExecOptions) (container.ExecCreateResponse, error) {
+	return container.ExecCreateResponse{}, fmt.Errorf("exec: %w", cerrdefs.ErrNotImplemented)
+}
+
+func (e *Engine) ContainerExecAttach(ctx context.Context, execID string, config container.ExecAttachOptions) (types.HijackedResponse, error) {
+	return types.HijackedResponse{}, fmt.Errorf("exec: %w", cerrdefs.ErrNotImplemented)
+}
+
+func (e *Engine) ContainerExecInspect(ctx context.Context, execID string) (container.ExecInspect, error) {
+	return container.ExecInspect{}, fmt.Errorf("exec: %w", cerrdefs.ErrNotImplemented)
+}
+
+func (e *Engine) ImagePull(ctx context.Context, refStr string, options image.PullOptions) (io.ReadCloser, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.record("ImagePull", refStr)
+
+	if err := e.pullErrs[refStr]; err != nil {
+		return nil, err
+	}
+	e.tagImage(refStr)
+	body := fmt.Sprintf(`{"status":"Status: Downloaded newer image for %s"}`+"\n", refStr)
+	return io.NopCloser(strings.NewReader(body)), nil
+}
+
+func (e *Engine) ImageBuild(ctx context.Context, buildContext io.Reader, options build.ImageBuildOptions) (build.ImageBuildResponse, error) {
+	files := map[string]string{}
+	tr := tar.NewReader(buildContext)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return build.ImageBuildResponse{}, fmt.Errorf("read build context: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return build.ImageBuildResponse{}, fmt.Errorf("read build context: %w", err)
+		}
+		files[hdr.Name] = string(content)
+	}
+	// Drain whatever follows the end of the archive, like the daemon does.
+	if _, err := io.Copy(io.Discard, buildContext); err != nil {
+		return build.ImageBuildResponse{}, fmt.Errorf("read build context: %w", err)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.record("ImageBuild", strings.Join(options.Tags, ","))
+
+	for _, tag := range options.Tags {
+		e.tagImage(tag)
+		e.builds[tag] = Build{Options: options, Files: files}
+	}
+	body := `{"stream":"Successfully built fake\n"}` + "\n"
+	return build.ImageBuildResponse{Body: io.NopCloser(strings.NewReader(body))}, nil
+}
+
+func (e *Engine) ImageInspect(ctx context.Context, imageID string, inspectOpts ...client.ImageInspectOption) (image.InspectResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	img, ok := e.images[imageID]
+	if !ok {
+		return image.InspectResponse{}, fmt.Errorf("No such image: %s: %w", imageID, cerrdefs.ErrNotFound)
+	}
+	return image.InspectResponse{
+		ID:       img.id,
+		RepoTags: []string{imageID},
+		Metadata: image.Metadata{LastTagTime: img.tagged},
+	}, nil
+}
+
+func (e *Engine) ImageRemove(ctx context.Context, imageID string, options image.RemoveOptions) ([]image.DeleteResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.record("ImageRemove", imageID)
+
+	if _, ok := e.images[imageID]; !ok {
+		return nil, fmt.Errorf("No such image: %s: %w", imageID, cerrdefs.ErrNotFound)
+	}
+	delete(e.images, imageID)
+	return []image.DeleteResponse{{Untagged: imageID}}, nil
+}
+
+func (e *Engine) NetworkCreate(ctx context.Context, name string, options network.CreateOptions) (network.CreateResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.record("NetworkCreate", name)
+
+	if _, ok := e.networks[name]; ok {
+		return network.CreateResponse{}, fmt.Errorf("network with name %s already exists: %w", name, cerrdefs.ErrConflict)
+	}
+	id := e.newID()
+	e.networks[name] = network.Inspect{
+		ID:         id,
+		Name:       name,
+		Driver:     options.Driver,
+		Internal:   options.Internal,
+		Attachable: options.Attachable,
+		Options:    options.Options,
+		Labels:     options.Labels,
+	}
+	return network.CreateResponse{ID: id}, nil
+}
+
+func (e *Engine) network(nameOrID string) (network.Inspect, error) {
+	for _, nw := range e.networks {
+		if nw.ID == nameOrID || nw.Name == nameOrID {
+			return nw, nil
+		}
+	}
+	return network.Inspect{}, fmt.Errorf("network %s not found: %w", nameOrID, cerrdefs.ErrNotFound)
+}
+
+func (e *Engine) NetworkInspect(ctx context.Context, networkID string, options network.InspectOptions) (network.Inspect, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.network(networkID)
+}
+
+func (e *Engine) NetworkList(ctx context.Context, options network.ListOptions) ([]network.Summary, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var list []network.Summary
+	for _, nw := range e.networks {
+		if matchLabels(nw.Labels, options.Filters) {
+			list = append(list, nw)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+func (e *Engine) NetworkConnect(ctx context.Context, networkID, containerID string, config *network.EndpointSettings) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	nw, err := e.network(networkID)
+	if err != nil {
+		return err
+	}
+	c, err := e.lookup(containerID)
+	if err != nil {
+		return err
+	}
+	e.record("NetworkConnect", nw.Name+" "+c.Name)
+	c.Networks[nw.Name] = config
+	return nil
+}
+
+func (e *Engine) NetworkRemove(ctx context.Context, networkID string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	nw, err := e.network(networkID)
+	if err != nil {
+		return err
+	}
+	e.record("NetworkRemove", nw.Name)
+	delete(e.networks, nw.Name)
+	return nil
+}
+
+func (e *Engine) VolumeCreate(ctx context.Context, options volume.CreateOptions) (volume.Volume, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.record("VolumeCreate", options.Name)
+
+	if vol, ok := e.volumes[options.Name]; ok {
+		return vol, nil
+	}
+	vol := volume.Volume{
+		Name:    options.Name,
+		Driver:  options.Driver,
+		Options: options.DriverOpts,
+		Labels:  options.Labels,
+	}
+	e.volumes[options.Name] = vol
+	return vol, nil
+}
+
+func (e *Engine) VolumeInspect(ctx context.Context, volumeID string) (volume.Volume, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	vol, ok := e.volumes[volumeID]
+	if !ok {
+		return volume.Volume{}, fmt.Errorf("get %s: no such volume: %w", volumeID, cerrdefs.ErrNotFound)
+	}
+	return vol, nil
+}
+
+func (e *Engine) VolumeList(ctx context.Context, options volume.ListOptions) (volume.ListResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var list []*volume.Volume
+	for _, vol := range e.volumes {
+		if matchLabels(vol.Labels, options.Filters) {
+			vol := vol
+			list = append(list, &vol)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return volume.ListResponse{Volumes: list}, nil
+}
+
+func (e *Engine) VolumeRemove(ctx context.Context, volumeID string, force bool) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.volumes[volumeID]; !ok {
+		return fmt.Errorf("get %s: no such volume: %w", volumeID, cerrdefs.ErrNotFound)
+	}
+	e.record("VolumeRemove", volumeID)
+	delete(e.volumes, volumeID)
+	return nil
+}
+
+// publish sends a container event to the matching subscribers. The caller
+// holds e.mu.
+func (e *Engine) publish(c *Container, action events.Action, extra map[string]string) {
+	now := time.Now()
+	attrs := map[string]string{"name": c.Name, "image": c.Config.Image}
+	for k, v := range c.Config.Labels {
+		attrs[k] = v
+	}
+	for k, v := range extra {
+		attrs[k] = v
+	}
+	msg := events.Message{
+		Type:     events.ContainerEventType,
+		Action:   action,
+		Actor:    events.Actor{ID: c.ID, Attributes: attrs},
+		Scope:    "local",
+		Time:     now.Unix(),
+		TimeNano: now.UnixNano(),
+	}
+	e.history = append(e.history, msg)
+	for sub := range e.subscribers {
+		if matchEvent(msg, sub.filters) {
+			sub.send(msg)
+		}
+	}
+}
+
+// matchEvent applies the type, label and event filters like the daemon,
+// where "health_status" matches every health status.
+func matchEvent(msg events.Message, args filters.Args) bool {
+	if types := args.Get("type"); len(types) > 0 && !slices.Contains(types, string(msg.Type)) {
+		return false
+	}
+	if !matchLabels(msg.Actor.Attributes, args) {
+		return false
+	}
+	actions := args.Get("event")
+	if len(actions) == 0 {
+		return true
+	}
+	for _, action := range actions {
+		if string(msg.Action) == action || strings.HasPrefix(string(msg.Action), action+":") {
+			return true
+		}
+	}
+	return false
+}
+
+// Events streams container events matching the filters, starting with the
+// ones since options.Since, until ctx is done or DisconnectEvents is called.
+func (e *Engine) Events(ctx context.Context, options events.ListOptions) (<-chan events.Message, <-chan error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var since time.Time
+	if options.Since != "" {
+		if secs, err := strconv.ParseFloat(options.Since, 64); err == nil {
+			since = time.Unix(0, int64(secs*1e9))
+		}
+	}
+
+	sub := &subscriber{
+		filters: options.Filters,
+		msgs:    make(chan events.Message, 1024),
+		errs:    make(chan error, 1),
+	}
+	for _, msg := range e.history {
+		if msg.TimeNano >= since.UnixNano() && matchEvent(msg, sub.filters) {
+			sub.send(msg)
+		}
+	}
+	e.subscribers[sub] = struct{}{}
+
+	go func() {
+		<-ctx.Done()
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if _, ok := e.subscribers[sub]; ok {
+			delete(e.subscribers, sub)
+			sub.errs <- ctx.Err()
+		}
+	}()
+	return sub.msgs, sub.errs
+}
+
+// DisconnectEvents ends every open Events stream with err, like a dropped
+// connection to the daemon.
+func (e *Engine) DisconnectEvents(err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for sub := range e.subscribers {
+		delete(e.subscribers, sub)
+		sub.errs <- err
+	}
+}
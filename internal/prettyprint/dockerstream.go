@@ -22,9 +22,9 @@ type dockerStreamEvent struct {
 }
 
 // PrintDockerStreamProgress reads from a Docker API response stream (like ImageBuild or ImagePull)
-// and prints the progress or logs to stdout. It also checks for and returns any errors reported
+// and prints the progress or logs to w. It also checks for and returns any errors reported
 // in the stream.
-func PrintDockerStreamProgress(r io.Reader) error {
+func PrintDockerStreamProgress(w io.Writer, r io.Reader) error {
 	scanner := bufio.NewScanner(r)
 	statusMap := make(map[string]string) // To track and update multi-line progress efficiently
 
@@ -34,7 +34,7 @@ func PrintDockerStreamProgress(r io.Reader) error {
 		if err := json.Unmarshal(line, &evt); err != nil {
 			// If it's not a JSON line, assume it's raw stream output and print it directly.
 			// This often happens at the very end or for initial status messages.
-			fmt.Println(string(line))
+			fmt.Fprintln(w, string(line))
 			continue
 		}
 
@@ -45,7 +45,7 @@ func PrintDockerStreamProgress(r io.Reader) error {
 
 		// Handle build stream output (often just 'stream' or 'status' messages)
 		if evt.Stream != "" {
-			fmt.Print(evt.Stream) // Print as is, Docker often adds newlines
+			fmt.Fprint(w, evt.Stream) // Print as is, Docker often adds newlines
 			continue
 		}
 
@@ -66,7 +66,7 @@ func PrintDockerStreamProgress(r io.Reader) error {
 			prev := statusMap[evt.ID]
 			if prev != displayLine {
 				statusMap[evt.ID] = displayLine
-				fmt.Println(displayLine)
+				fmt.Fprintln(w, displayLine)
 			}
 		}
 	}
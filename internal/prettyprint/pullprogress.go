@@ -1,10 +1,14 @@
 package prettyprint
 
 import (
-	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"strings"
+	"time"
+
+	"github.com/docker/go-units"
 )
 
 type progressDetail struct {
@@ -17,42 +21,157 @@ type pullEvent struct {
 	Status         string         `json:"status"`
 	Progress       string         `json:"progress"`
 	ProgressDetail progressDetail `json:"progressDetail"`
+	Error          string         `json:"error"`
+	ErrorDetail    struct {
+		Message string `json:"message"`
+	} `json:"errorDetail"`
+}
+
+// layerProgress tracks the download of one image layer.
+type layerProgress struct {
+	current int64
+	total   int64
+	done    bool
+}
+
+// ProgressInterval throttles progress updates so a pull doesn't flood the output.
+const ProgressInterval = 250 * time.Millisecond
+
+// PullProgress is a snapshot of an image pull, aggregated over its layers.
+type PullProgress struct {
+	Layers     int
+	LayersDone int
+	// Current and Total are the bytes downloaded so far and the bytes known
+	// to be needed, which grows as the daemon learns about more layers.
+	Current int64
+	Total   int64
+	Elapsed time.Duration
+	// Status is the last status message of the pull, e.g. "Downloaded newer
+	// image for alpine:latest" once it's done.
+	Status string
+}
+
+// String formats the progress as "N/M layers  cur/total  pct%  ETA".
+func (p PullProgress) String() string {
+	line := fmt.Sprintf("%d/%d layers", p.LayersDone, p.Layers)
+	if p.Total <= 0 {
+		return line
+	}
+
+	pct := float64(p.Current) / float64(p.Total) * 100
+	line += fmt.Sprintf("  %s/%s  %3.0f%%", units.HumanSize(float64(p.Current)), units.HumanSize(float64(p.Total)), pct)
+	if p.Current > 0 && p.Current < p.Total {
+		eta := time.Duration(float64(p.Elapsed) * float64(p.Total-p.Current) / float64(p.Current))
+		line += fmt.Sprintf("  ETA %s", eta.Round(time.Second))
+	}
+	return line
+}
+
+// Summary formats a finished pull as "<status> (N/M layers, size) in <time>".
+func (p PullProgress) Summary() string {
+	status := p.Status
+	if status == "" {
+		status = "Pull complete"
+	}
+	return fmt.Sprintf("%s (%d/%d layers, %s) in %s", status, p.LayersDone, p.Layers, units.HumanSize(float64(p.Total)), p.Elapsed.Round(100*time.Millisecond))
 }
 
-// PrintPullProgress reads from the Docker image pull response and displays it cleanly.
-func PrintPullProgress(r io.Reader) error {
-	scanner := bufio.NewScanner(r)
-	statusMap := make(map[string]string)
+// ReadPullProgress reads the Docker image pull response and calls onProgress
+// with the aggregated progress at most once per interval. It returns the final
+// progress once the stream ends. Errors the daemon reports inside the stream
+// are returned as Go errors.
+func ReadPullProgress(r io.Reader, interval time.Duration, onProgress func(PullProgress)) (PullProgress, error) {
+	start := time.Now()
+	layers := map[string]*layerProgress{}
+	var order []string
+	var lastStatus string
+	var lastReport time.Time
 
-	for scanner.Scan() {
+	snapshot := func() PullProgress {
+		current, total, done := aggregate(layers, order)
+		return PullProgress{
+			Layers:     len(order),
+			LayersDone: done,
+			Current:    current,
+			Total:      total,
+			Elapsed:    time.Since(start),
+			Status:     strings.TrimPrefix(lastStatus, "Status: "),
+		}
+	}
+
+	decoder := json.NewDecoder(r)
+	for {
 		var evt pullEvent
-		if err := json.Unmarshal(scanner.Bytes(), &evt); err != nil {
-			// If the line is not JSON, skip it.
-			continue
+		if err := decoder.Decode(&evt); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return snapshot(), fmt.Errorf("error reading pull stream: %w", err)
 		}
 
-		// Skip duplicate or empty
-		if evt.ID == "" && evt.Status != "" {
-			fmt.Println(evt.Status)
-			continue
+		if evt.Error != "" || evt.ErrorDetail.Message != "" {
+			msg := evt.Error
+			if msg == "" {
+				msg = evt.ErrorDetail.Message
+			}
+			return snapshot(), fmt.Errorf("pull error: %s", msg)
 		}
-		if evt.ID == "" {
+
+		if !isLayerStatus(evt.Status) || evt.ID == "" {
+			if evt.Status != "" {
+				lastStatus = evt.Status
+			}
 			continue
 		}
 
-		// Compose display string
-		line := fmt.Sprintf("[%s] %s", evt.ID, evt.Status)
-		if evt.Progress != "" {
-			line += " " + evt.Progress
+		layer, ok := layers[evt.ID]
+		if !ok {
+			layer = &layerProgress{}
+			layers[evt.ID] = layer
+			order = append(order, evt.ID)
+		}
+		switch evt.Status {
+		case "Downloading":
+			layer.current = evt.ProgressDetail.Current
+			if evt.ProgressDetail.Total > 0 {
+				layer.total = evt.ProgressDetail.Total
+			}
+		case "Download complete", "Extracting", "Pull complete", "Already exists":
+			layer.done = true
 		}
 
-		// Only update if status actually changed
-		prev := statusMap[evt.ID]
-		if prev != line {
-			statusMap[evt.ID] = line
-			fmt.Println(line)
+		if onProgress != nil && time.Since(lastReport) >= interval {
+			onProgress(snapshot())
+			lastReport = time.Now()
 		}
 	}
 
-	return scanner.Err()
+	return snapshot(), nil
+}
+
+func isLayerStatus(status string) bool {
+	switch status {
+	case "Pulling fs layer", "Waiting", "Downloading", "Verifying Checksum",
+		"Download complete", "Extracting", "Pull complete", "Already exists":
+		return true
+	}
+	return false
+}
+
+// aggregate sums up the bytes downloaded so far, the total bytes known and the
+// number of finished layers.
+func aggregate(layers map[string]*layerProgress, order []string) (int64, int64, int) {
+	var current, total int64
+	done := 0
+	for _, id := range order {
+		l := layers[id]
+		total += l.total
+		if l.done {
+			current += l.total
+			done++
+		} else {
+			current += l.current
+		}
+	}
+	return current, total, done
 }
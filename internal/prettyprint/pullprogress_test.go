@@ -0,0 +1,70 @@
+package prettyprint
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadPullProgress(t *testing.T) {
+	tests := []struct {
+		name           string
+		stream         string
+		expectErr      string
+		expectSummary  string
+		expectLastLine string
+	}{
+		{
+			name: "Summary_after_all_layers",
+			stream: `{"status":"Pulling from library/alpine","id":"latest"}
+{"status":"Pulling fs layer","progressDetail":{},"id":"aaa"}
+{"status":"Pulling fs layer","progressDetail":{},"id":"bbb"}
+{"status":"Downloading","progressDetail":{"current":500,"total":1000},"id":"aaa"}
+{"status":"Downloading","progressDetail":{"current":1000,"total":3000},"id":"bbb"}
+{"status":"Download complete","progressDetail":{},"id":"aaa"}
+{"status":"Pull complete","progressDetail":{},"id":"aaa"}
+{"status":"Pull complete","progressDetail":{},"id":"bbb"}
+{"status":"Digest: sha256:abc"}
+{"status":"Status: Downloaded newer image for alpine:latest"}
+`,
+			expectLastLine: "2/2 layers  4kB/4kB  100%",
+			expectSummary:  "Downloaded newer image for alpine:latest (2/2 layers, 4kB) in",
+		},
+		{
+			name: "Stream_error_is_returned",
+			stream: `{"status":"Pulling from library/private","id":"latest"}
+{"errorDetail":{"message":"manifest unknown"},"error":"manifest unknown"}
+`,
+			expectErr: "manifest unknown",
+		},
+		{
+			name: "Progress_is_aggregated_over_layers",
+			stream: `{"status":"Downloading","progressDetail":{"current":250,"total":1000},"id":"aaa"}
+{"status":"Status: Image is up to date for alpine:latest"}
+`,
+			expectLastLine: "0/1 layers  250B/1kB   25%",
+			expectSummary:  "Image is up to date for alpine:latest (0/1 layers, 1kB) in",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var updates []string
+			progress, err := ReadPullProgress(strings.NewReader(tt.stream), 0, func(p PullProgress) {
+				updates = append(updates, p.String())
+			})
+			if tt.expectErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Contains(t, progress.Summary(), tt.expectSummary)
+			require.NotEmpty(t, updates)
+			last := updates[len(updates)-1]
+			assert.True(t, strings.HasPrefix(last, tt.expectLastLine), "progress %q should start with %q", last, tt.expectLastLine)
+		})
+	}
+}
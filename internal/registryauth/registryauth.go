@@ -0,0 +1,288 @@
+// Package registryauth resolves registry credentials the way the docker CLI
+// does, from the auths, credsStore and credHelpers of a docker config.json.
+package registryauth
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types/registry"
+)
+
+// DockerHubServer is the key docker uses for Docker Hub in config.json.
+const DockerHubServer = "https://index.docker.io/v1/"
+
+// configFile is the part of config.json that holds credentials.
+type configFile struct {
+	Auths       map[string]authEntry `json:"auths"`
+	CredsStore  string               `json:"credsStore"`
+	CredHelpers map[string]string    `json:"credHelpers"`
+}
+
+type authEntry struct {
+	Auth          string `json:"auth"`
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	IdentityToken string `json:"identitytoken"`
+	RegistryToken string `json:"registrytoken"`
+}
+
+// Store resolves credentials from one docker config file.
+type Store struct {
+	config configFile
+}
+
+// ConfigDir returns the directory docker reads config.json from: $DOCKER_CONFIG
+// or ~/.docker.
+func ConfigDir() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return dir
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".docker"
+	}
+	return filepath.Join(home, ".docker")
+}
+
+// Load reads config.json from configDir, or from ConfigDir when it's empty.
+// A missing file isn't an error, it yields a store without credentials.
+func Load(configDir string) (*Store, error) {
+	if configDir == "" {
+		configDir = ConfigDir()
+	}
+	path := filepath.Join(configDir, "config.json")
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &Store{}, nil
+		}
+		return nil, fmt.Errorf("read docker config %s: %w", path, err)
+	}
+
+	s := &Store{}
+	if err := json.Unmarshal(data, &s.config); err != nil {
+		return nil, fmt.Errorf("parse docker config %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// ServerForImage returns the config.json key of the registry an image is
+// pulled from.
+func ServerForImage(imageName string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(imageName)
+	if err != nil {
+		return "", fmt.Errorf("parse image reference %s: %w", imageName, err)
+	}
+	domain := reference.Domain(named)
+	if domain == "docker.io" || domain == "index.docker.io" {
+		return DockerHubServer, nil
+	}
+	return domain, nil
+}
+
+// Lookup returns the credentials for a registry. The registry's credHelper
+// wins, then the credsStore, then the plain auths entry. An empty config is
+// returned when there are none.
+func (s *Store) Lookup(ctx context.Context, server string) (registry.AuthConfig, error) {
+	host := hostname(server)
+
+	helper := s.config.CredHelpers[host]
+	if helper == "" && host == hostname(DockerHubServer) {
+		helper = s.config.CredHelpers[DockerHubServer]
+	}
+	if helper == "" {
+		helper = s.config.CredsStore
+	}
+	if helper != "" {
+		auth, found, err := helperGet(ctx, helper, server)
+		if err != nil {
+			return registry.AuthConfig{}, err
+		}
+		if found {
+			return auth, nil
+		}
+	}
+
+	for key, entry := range s.config.Auths {
+		if hostname(key) != host {
+			continue
+		}
+		auth, err := entry.authConfig(server)
+		if err != nil {
+			return registry.AuthConfig{}, fmt.Errorf("docker config auths entry %s: %w", key, err)
+		}
+		return auth, nil
+	}
+	return registry.AuthConfig{ServerAddress: server}, nil
+}
+
+// EncodedForImage returns the credentials for the image's registry encoded
+// for the RegistryAuth field of the docker API.
+func (s *Store) EncodedForImage(ctx context.Context, imageName string) (string, error) {
+	server, err := ServerForImage(imageName)
+	if err != nil {
+		return "", err
+	}
+	auth, err := s.Lookup(ctx, server)
+	if err != nil {
+		return "", err
+	}
+	if auth.Username == "" && auth.Password == "" && auth.IdentityToken == "" && auth.RegistryToken == "" {
+		return "", nil
+	}
+	return registry.EncodeAuthConfig(auth)
+}
+
+// All returns the credentials of every registry the config knows about, as
+// needed for the AuthConfigs of an image build. Like the docker CLI it asks
+// the credsStore to list its registries too, as stores such as the desktop
+// or keychain ones keep registries that aren't in auths.
+func (s *Store) All(ctx context.Context) (map[string]registry.AuthConfig, error) {
+	servers := map[string]bool{}
+	for key := range s.config.Auths {
+		servers[key] = true
+	}
+	for key := range s.config.CredHelpers {
+		servers[key] = true
+	}
+	if s.config.CredsStore != "" {
+		stored, err := helperList(ctx, s.config.CredsStore)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range stored {
+			servers[key] = true
+		}
+	}
+
+	keys := make([]string, 0, len(servers))
+	for key := range servers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	all := make(map[string]registry.AuthConfig, len(keys))
+	for _, key := range keys {
+		auth, err := s.Lookup(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		all[key] = auth
+	}
+	return all, nil
+}
+
+func (e authEntry) authConfig(server string) (registry.AuthConfig, error) {
+	auth := registry.AuthConfig{
+		Username:      e.Username,
+		Password:      e.Password,
+		IdentityToken: e.IdentityToken,
+		RegistryToken: e.RegistryToken,
+		ServerAddress: server,
+	}
+	if e.Auth != "" {
+		decoded, err := base64.StdEncoding.DecodeString(e.Auth)
+		if err != nil {
+			return registry.AuthConfig{}, fmt.Errorf("decode auth: %w", err)
+		}
+		user, password, ok := strings.Cut(string(decoded), ":")
+		if !ok {
+			return registry.AuthConfig{}, fmt.Errorf("auth is not in user:password form")
+		}
+		auth.Username = user
+		auth.Password = strings.Trim(password, "\x00")
+	}
+	return auth, nil
+}
+
+// helperCredentials is what a docker-credential-* helper prints for get.
+type helperCredentials struct {
+	ServerURL string `json:"ServerURL"`
+	Username  string `json:"Username"`
+	Secret    string `json:"Secret"`
+}
+
+// runHelper runs docker-credential-<helper> with the action and input on
+// stdin. On failure it returns what the helper printed along with the error.
+func runHelper(ctx context.Context, helper, action, input string) ([]byte, string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "docker-credential-"+helper, action)
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, strings.TrimSpace(stdout.String() + stderr.String()), err
+	}
+	return stdout.Bytes(), "", nil
+}
+
+// helperList asks docker-credential-<helper> for the registries it has
+// credentials for.
+func helperList(ctx context.Context, helper string) ([]string, error) {
+	out, msg, err := runHelper(ctx, helper, "list", "")
+	if err != nil {
+		return nil, fmt.Errorf("credential helper %s list: %w: %s", helper, err, msg)
+	}
+
+	// list prints a map of server URL to user name.
+	var stored map[string]string
+	if err := json.Unmarshal(out, &stored); err != nil {
+		return nil, fmt.Errorf("credential helper %s list: invalid output: %w", helper, err)
+	}
+	servers := make([]string, 0, len(stored))
+	for server := range stored {
+		servers = append(servers, server)
+	}
+	return servers, nil
+}
+
+// helperGet asks docker-credential-<helper> for the credentials of server.
+// found is false when the helper has none stored.
+func helperGet(ctx context.Context, helper, server string) (registry.AuthConfig, bool, error) {
+	out, msg, err := runHelper(ctx, helper, "get", server)
+	if err != nil {
+		if strings.Contains(msg, "credentials not found") {
+			return registry.AuthConfig{}, false, nil
+		}
+		return registry.AuthConfig{}, false, fmt.Errorf("credential helper %s for %s: %w: %s", helper, server, err, msg)
+	}
+
+	var creds helperCredentials
+	if err := json.Unmarshal(out, &creds); err != nil {
+		return registry.AuthConfig{}, false, fmt.Errorf("credential helper %s for %s: invalid output: %w", helper, server, err)
+	}
+
+	auth := registry.AuthConfig{ServerAddress: server}
+	// Helpers store identity tokens under this placeholder user name.
+	if creds.Username == "<token>" {
+		auth.IdentityToken = creds.Secret
+	} else {
+		auth.Username = creds.Username
+		auth.Password = creds.Secret
+	}
+	return auth, true, nil
+}
+
+// hostname strips the scheme and path from a config.json server key, so that
+// "https://registry.example.com/v1/" and "registry.example.com" match.
+func hostname(server string) string {
+	host := server
+	if i := strings.Index(host, "://"); i >= 0 {
+		host = host[i+3:]
+	}
+	host, _, _ = strings.Cut(host, "/")
+	return host
+}
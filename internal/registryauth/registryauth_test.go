@@ -0,0 +1,180 @@
+package registryauth
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types/registry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore_Lookup(t *testing.T) {
+	// A fake credential helper that knows one registry and stores an identity
+	// token for another.
+	binDir := t.TempDir()
+	helper := `#!/bin/sh
+read server
+case "$server" in
+  helper.example.com) echo '{"ServerURL":"helper.example.com","Username":"helperuser","Secret":"helperpass"}' ;;
+  token.example.com) echo '{"ServerURL":"token.example.com","Username":"<token>","Secret":"idtoken"}' ;;
+  *) echo "credentials not found in native keychain"; exit 1 ;;
+esac
+`
+	require.NoError(t, os.WriteFile(filepath.Join(binDir, "docker-credential-fake"), []byte(helper), 0755))
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	basic := func(user, password string) string {
+		return base64.StdEncoding.EncodeToString([]byte(user + ":" + password))
+	}
+
+	tests := []struct {
+		name       string
+		config     string
+		image      string
+		expectAuth registry.AuthConfig
+	}{
+		{
+			name:   "Auths_entry_for_docker_hub",
+			config: `{"auths":{"https://index.docker.io/v1/":{"auth":"` + basic("hubuser", "hubpass") + `"}}}`,
+			image:  "someone/private:latest",
+			expectAuth: registry.AuthConfig{
+				Username:      "hubuser",
+				Password:      "hubpass",
+				ServerAddress: DockerHubServer,
+			},
+		},
+		{
+			name:   "Auths_entry_with_scheme_matches_host",
+			config: `{"auths":{"https://localhost:5000":{"auth":"` + basic("local", "secret") + `"}}}`,
+			image:  "localhost:5000/app:1",
+			expectAuth: registry.AuthConfig{
+				Username:      "local",
+				Password:      "secret",
+				ServerAddress: "localhost:5000",
+			},
+		},
+		{
+			name:   "Cred_helper_wins_over_auths",
+			config: `{"auths":{"helper.example.com":{"auth":"` + basic("fileuser", "filepass") + `"}},"credHelpers":{"helper.example.com":"fake"}}`,
+			image:  "helper.example.com/app",
+			expectAuth: registry.AuthConfig{
+				Username:      "helperuser",
+				Password:      "helperpass",
+				ServerAddress: "helper.example.com",
+			},
+		},
+		{
+			name:   "Creds_store_identity_token",
+			config: `{"credsStore":"fake"}`,
+			image:  "token.example.com/app",
+			expectAuth: registry.AuthConfig{
+				IdentityToken: "idtoken",
+				ServerAddress: "token.example.com",
+			},
+		},
+		{
+			name:   "Creds_store_miss_falls_back_to_auths",
+			config: `{"credsStore":"fake","auths":{"other.example.com":{"username":"u","password":"p"}}}`,
+			image:  "other.example.com/app",
+			expectAuth: registry.AuthConfig{
+				Username:      "u",
+				Password:      "p",
+				ServerAddress: "other.example.com",
+			},
+		},
+		{
+			name:       "No_credentials",
+			config:     `{}`,
+			image:      "alpine",
+			expectAuth: registry.AuthConfig{ServerAddress: DockerHubServer},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "config.json"), []byte(tt.config), 0600))
+
+			store, err := Load(dir)
+			require.NoError(t, err)
+
+			server, err := ServerForImage(tt.image)
+			require.NoError(t, err)
+			auth, err := store.Lookup(context.Background(), server)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectAuth, auth)
+
+			encoded, err := store.EncodedForImage(context.Background(), tt.image)
+			require.NoError(t, err)
+			if tt.expectAuth.Username == "" && tt.expectAuth.IdentityToken == "" {
+				assert.Empty(t, encoded)
+				return
+			}
+			decoded, err := registry.DecodeAuthConfig(encoded)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectAuth, *decoded)
+		})
+	}
+}
+
+func TestStore_AllListsCredsStore(t *testing.T) {
+	// A fake credential store holding a registry that isn't in auths, as the
+	// desktop and keychain stores do.
+	binDir := t.TempDir()
+	helper := `#!/bin/sh
+case "$1" in
+  list) echo '{"https://index.docker.io/v1/":"hubuser","private.example.com":"storeuser"}' ;;
+  get)
+    read server
+    case "$server" in
+      private.example.com) echo '{"ServerURL":"private.example.com","Username":"storeuser","Secret":"storepass"}' ;;
+      https://index.docker.io/v1/) echo '{"ServerURL":"https://index.docker.io/v1/","Username":"hubuser","Secret":"hubpass"}' ;;
+      *) echo "credentials not found in native keychain"; exit 1 ;;
+    esac ;;
+esac
+`
+	require.NoError(t, os.WriteFile(filepath.Join(binDir, "docker-credential-fakestore"), []byte(helper), 0755))
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	dir := t.TempDir()
+	config := `{"credsStore":"fakestore","auths":{"https://index.docker.io/v1/":{}}}`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0600))
+
+	store, err := Load(dir)
+	require.NoError(t, err)
+
+	all, err := store.All(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]registry.AuthConfig{
+		DockerHubServer:       {Username: "hubuser", Password: "hubpass", ServerAddress: DockerHubServer},
+		"private.example.com": {Username: "storeuser", Password: "storepass", ServerAddress: "private.example.com"},
+	}, all)
+}
+
+func TestLoad_MissingConfig(t *testing.T) {
+	store, err := Load(t.TempDir())
+	require.NoError(t, err)
+
+	all, err := store.All(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, all)
+}
+
+func TestLoad_DockerConfigEnv(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"auths":{"registry.example.com":{"username":"u","password":"p"}}}`), 0600))
+	t.Setenv("DOCKER_CONFIG", dir)
+
+	store, err := Load("")
+	require.NoError(t, err)
+
+	all, err := store.All(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]registry.AuthConfig{
+		"registry.example.com": {Username: "u", Password: "p", ServerAddress: "registry.example.com"},
+	}, all)
+}
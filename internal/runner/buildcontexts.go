@@ -0,0 +1,185 @@
+package runner
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/JamesTiberiusKirk/go-docker-compose/internal/composeconvert"
+	"github.com/JamesTiberiusKirk/go-docker-compose/internal/prettyprint"
+	"github.com/compose-spec/compose-go/types"
+	"github.com/docker/docker/api/types/build"
+	"github.com/docker/docker/api/types/image"
+	"github.com/moby/go-archive"
+)
+
+// The docker build API has no named contexts, so additional_contexts are
+// turned into stages the Dockerfile can refer to by name, the way buildx
+// named contexts work: an image context becomes `FROM <image> AS <name>` and
+// a directory is first built into a scratch image holding its files.
+
+// contextImageRepo is the repository the images built from additional
+// context directories are tagged in until the build is done.
+const contextImageRepo = "go-docker-compose-context"
+
+// contextDockerfile copies a directory context into an otherwise empty image.
+const contextDockerfile = "FROM scratch\nCOPY . /\n"
+
+// additionalContexts returns the build's additional contexts with the local
+// directories among them resolved against the project directory. Image,
+// URL and git contexts are returned as they are.
+func additionalContexts(stackConfig *types.Project, cfg *types.BuildConfig) map[string]string {
+	if len(cfg.AdditionalContexts) == 0 {
+		return nil
+	}
+	resolved := make(map[string]string, len(cfg.AdditionalContexts))
+	for name, path := range cfg.AdditionalContexts {
+		if !isRemoteContext(path) {
+			path = composeconvert.ResolveProjectPath(stackConfig, path)
+		}
+		resolved[name] = path
+	}
+	return resolved
+}
+
+// contextImages returns the image each additional context's stage starts
+// from, building the ones for directories. remove deletes the images it
+// built and is safe to call when an error is returned.
+func contextImages(ctx context.Context, cli Engine, out io.Writer, serviceName string, contexts map[string]string) (map[string]string, func(), error) {
+	var built []string
+	remove := func() {
+		for _, ref := range built {
+			_, _ = cli.ImageRemove(context.WithoutCancel(ctx), ref, image.RemoveOptions{PruneChildren: true})
+		}
+	}
+
+	images := make(map[string]string, len(contexts))
+	for name, source := range contexts {
+		if ref, ok := strings.CutPrefix(source, "docker-image://"); ok {
+			images[name] = ref
+			continue
+		}
+		if isRemoteContext(source) {
+			return nil, remove, fmt.Errorf("additional context %s of service %s: %s isn't supported by the docker build API, only docker-image:// and local directories are", name, serviceName, source)
+		}
+
+		ref, err := buildContextImage(ctx, cli, out, serviceName, name, source)
+		if err != nil {
+			return nil, remove, err
+		}
+		built = append(built, ref)
+		images[name] = ref
+	}
+	return images, remove, nil
+}
+
+// buildContextImage builds the directory into a scratch image holding its
+// files, honouring its .dockerignore, and returns the image's tag.
+func buildContextImage(ctx context.Context, cli Engine, out io.Writer, serviceName, name, dir string) (string, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to generate tag: %w", err)
+	}
+	ref := contextImageRepo + ":" + hex.EncodeToString(id)
+	fmt.Fprintf(out, "Building additional context '%s' from '%s' for service: %s\n", name, dir, serviceName)
+
+	excludes, err := readDockerignore(dir, "", true)
+	if err != nil {
+		return "", err
+	}
+	tarReader, err := archive.TarWithOptions(dir, &archive.TarOptions{ExcludePatterns: excludes})
+	if err != nil {
+		return "", fmt.Errorf("failed to create tar archive for additional context '%s': %w", dir, err)
+	}
+	const dockerfile = ".dockerfile.context"
+	tarReader = addDockerfileToContext(tarReader, dockerfile, []byte(contextDockerfile))
+	defer tarReader.Close()
+
+	resp, err := cli.ImageBuild(ctx, tarReader, build.ImageBuildOptions{
+		Tags:       []string{ref},
+		Dockerfile: dockerfile,
+		Remove:     true,
+	})
+	if err != nil {
+		return "", fmt.Errorf("build additional context %s of service %s: %w", name, serviceName, err)
+	}
+	defer resp.Body.Close()
+	if err := prettyprint.PrintDockerStreamProgress(io.Discard, resp.Body); err != nil {
+		return "", fmt.Errorf("build additional context %s of service %s: %w", name, serviceName, err)
+	}
+	return ref, nil
+}
+
+// stageNamePattern matches a FROM line naming its stage.
+var stageNamePattern = regexp.MustCompile(`(?i)^\s*FROM\s.*\sAS\s+(\S+)\s*$`)
+
+// addContextStages puts a `FROM <image> AS <name>` stage for every entry of
+// stages in front of the Dockerfile's first FROM, after its parser directives
+// and global ARGs.
+func addContextStages(dockerfile string, stages map[string]string) (string, error) {
+	lines := strings.Split(dockerfile, "\n")
+
+	escape := "\\"
+	insertAt := -1
+	// instruction collects the lines of an instruction continued with the
+	// escape character, it starts at line start.
+	var instruction strings.Builder
+	start := 0
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if directive, ok := strings.CutPrefix(trimmed, "#"); ok {
+			if key, value, ok := strings.Cut(directive, "="); ok && insertAt < 0 && strings.EqualFold(strings.TrimSpace(key), "escape") {
+				escape = strings.TrimSpace(value)
+			}
+			continue
+		}
+		if instruction.Len() == 0 {
+			start = i
+		}
+		if continued, ok := strings.CutSuffix(trimmed, escape); ok {
+			instruction.WriteString(continued + " ")
+			continue
+		}
+		instruction.WriteString(trimmed)
+		full := instruction.String()
+		instruction.Reset()
+
+		fields := strings.Fields(full)
+		if len(fields) == 0 || !strings.EqualFold(fields[0], "FROM") {
+			continue
+		}
+		if insertAt < 0 {
+			insertAt = start
+		}
+		if m := stageNamePattern.FindStringSubmatch(full); m != nil {
+			for name := range stages {
+				if strings.EqualFold(name, m[1]) {
+					return "", fmt.Errorf("additional context %s has the same name as a stage of the Dockerfile", name)
+				}
+			}
+		}
+	}
+	if insertAt < 0 {
+		return "", fmt.Errorf("the Dockerfile has no FROM instruction")
+	}
+
+	names := make([]string, 0, len(stages))
+	for name := range stages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	added := make([]string, 0, len(names))
+	for _, name := range names {
+		added = append(added, fmt.Sprintf("FROM %s AS %s", stages[name], name))
+	}
+
+	out := append([]string{}, lines[:insertAt]...)
+	out = append(out, added...)
+	out = append(out, lines[insertAt:]...)
+	return strings.Join(out, "\n"), nil
+}
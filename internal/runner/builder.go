@@ -1,73 +1,133 @@
 package runner
 
 import (
+	"archive/tar"
 	"context"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/JamesTiberiusKirk/go-docker-compose/internal/composeconvert"
 	"github.com/JamesTiberiusKirk/go-docker-compose/internal/prettyprint"
 	"github.com/compose-spec/compose-go/types"
 	"github.com/docker/docker/api/types/build"
-	"github.com/docker/docker/client"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/go-units"
 	"github.com/moby/go-archive"
 	"github.com/teris-io/shortid"
 )
 
-func buildImage(ctx context.Context, cli *client.Client, service types.ServiceConfig) error {
-	// TODO: here we need to append the context to the docker compose file path
-
+func buildImage(ctx context.Context, cli Engine, out io.Writer, stackConfig *types.Project, service types.ServiceConfig, authConfigs map[string]registry.AuthConfig) error {
 	effectiveBuildContextPath := service.Build.Context
 	if effectiveBuildContextPath == "" {
 		effectiveBuildContextPath = "."
 	}
+	if isRemoteContext(effectiveBuildContextPath) {
+		return fmt.Errorf("remote build context '%s' is not supported", effectiveBuildContextPath)
+	}
+	effectiveBuildContextPath = composeconvert.ResolveProjectPath(stackConfig, effectiveBuildContextPath)
 
 	imageName := service.Image
 	if imageName == "" {
 		imageName = service.Name
 	}
 
+	buildOptions, err := imageBuildOptions(service, imageName)
+	if err != nil {
+		return err
+	}
+
 	dockerfilePath := service.Build.Dockerfile
+	dockerfileContent := service.Build.DockerfileInline
+
+	excludes, err := readDockerignore(effectiveBuildContextPath, dockerfilePath, dockerfileContent != "")
+	if err != nil {
+		return err
+	}
 
-	if service.Build.DockerfileInline != "" {
-		tmpContext, dp, err := handleInlineDockerfile(effectiveBuildContextPath, service.Build.DockerfileInline, imageName, service.Name)
+	// Docker only reads the Dockerfile from inside the context, so one that
+	// lives elsewhere is sent along like an inline Dockerfile.
+	if dockerfileContent == "" && dockerfilePath != "" {
+		relPath, content, err := resolveDockerfile(effectiveBuildContextPath, dockerfilePath)
 		if err != nil {
 			return err
 		}
-		effectiveBuildContextPath = tmpContext
-		dockerfilePath = dp
+		dockerfilePath = relPath
+		dockerfileContent = content
+	}
+
+	// Additional contexts become stages in front of the Dockerfile, which
+	// then has to be sent along as well.
+	if contexts := additionalContexts(stackConfig, service.Build); len(contexts) > 0 {
+		if dockerfileContent == "" {
+			if dockerfilePath == "" {
+				dockerfilePath = "Dockerfile"
+			}
+			content, err := os.ReadFile(filepath.Join(effectiveBuildContextPath, dockerfilePath))
+			if err != nil {
+				return fmt.Errorf("failed to read Dockerfile: %w", err)
+			}
+			dockerfileContent = string(content)
+		}
+		stages, removeImages, err := contextImages(ctx, cli, out, service.Name, contexts)
+		defer removeImages()
+		if err != nil {
+			return err
+		}
+		if dockerfileContent, err = addContextStages(dockerfileContent, stages); err != nil {
+			return fmt.Errorf("service %s: %w", service.Name, err)
+		}
+	}
+
+	if dockerfileContent != "" {
+		sid, err := shortid.Generate()
+		if err != nil {
+			return fmt.Errorf("failed to create shortid: %w", err)
+		}
+		dockerfilePath = ".dockerfile." + sid
+		if service.Build.DockerfileInline != "" {
+			fmt.Fprintf(out, "Building image from inline Dockerfile in context '%s' for service: %s\n", effectiveBuildContextPath, service.Name)
+		} else {
+			fmt.Fprintf(out, "Building image from Dockerfile '%s' with context '%s' for service: %s\n", service.Build.Dockerfile, effectiveBuildContextPath, service.Name)
+		}
 	} else {
 		if dockerfilePath == "" {
 			dockerfilePath = "Dockerfile"
 		}
-		fmt.Printf("Building image from Dockerfile '%s' in context '%s' for service: %s\n", dockerfilePath, effectiveBuildContextPath, service.Name)
+		fmt.Fprintf(out, "Building image from Dockerfile '%s' in context '%s' for service: %s\n", dockerfilePath, effectiveBuildContextPath, service.Name)
 	}
 
 	if _, err := os.Stat(effectiveBuildContextPath); os.IsNotExist(err) {
 		return fmt.Errorf("build context directory '%s' does not exist: %w", effectiveBuildContextPath, err)
 	}
 
-	buildCtxReader, err := archive.TarWithOptions(effectiveBuildContextPath, &archive.TarOptions{})
+	keepDockerfile := dockerfilePath
+	if dockerfileContent != "" {
+		keepDockerfile = ""
+	}
+	excludes, err = trimBuildFilesFromExcludes(excludes, keepDockerfile)
 	if err != nil {
-		return fmt.Errorf("failed to create tar archive for build context '%s': %w", effectiveBuildContextPath, err)
+		return err
 	}
-	defer buildCtxReader.Close()
 
-	dockerBuildArgs := make(map[string]*string)
-	if service.Build.Args != nil {
-		for k, v := range service.Build.Args {
-			val := v
-			dockerBuildArgs[k] = val
-		}
+	tarReader, err := archive.TarWithOptions(effectiveBuildContextPath, &archive.TarOptions{
+		ExcludePatterns: excludes,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create tar archive for build context '%s': %w", effectiveBuildContextPath, err)
 	}
-
-	buildOptions := build.ImageBuildOptions{
-		Tags:       []string{imageName},
-		Remove:     true,
-		Dockerfile: dockerfilePath,
-		BuildArgs:  dockerBuildArgs,
+	if dockerfileContent != "" {
+		tarReader = addDockerfileToContext(tarReader, dockerfilePath, []byte(dockerfileContent))
 	}
+	defer tarReader.Close()
+	buildCtxReader := &countingReader{r: tarReader}
+
+	buildOptions.Dockerfile = dockerfilePath
+	buildOptions.AuthConfigs = authConfigs
 
 	imageBuildResp, err := cli.ImageBuild(ctx, buildCtxReader, buildOptions)
 	if err != nil {
@@ -75,86 +135,141 @@ func buildImage(ctx context.Context, cli *client.Client, service types.ServiceCo
 	}
 	defer imageBuildResp.Body.Close()
 
-	if err := prettyprint.PrintDockerStreamProgress(imageBuildResp.Body); err != nil {
+	if err := prettyprint.PrintDockerStreamProgress(out, imageBuildResp.Body); err != nil {
 		return fmt.Errorf("error from docker image build; service %s failed to build: %w", service.Name, err)
 	}
 
+	fmt.Fprintf(out, "Sent build context of %s for service: %s\n", units.HumanSize(float64(buildCtxReader.n.Load())), service.Name)
+
 	return nil
 }
 
-func handleInlineDockerfile(contextPath, inlineDockerFile, imageName, serviceName string) (string, string, error) {
-	sid, err := shortid.Generate()
-	if err != nil {
-		return "", "", fmt.Errorf("failed to create shortid: %w", err)
-	}
+// imageBuildOptions maps the service's build section onto the options of the
+// docker build API. Fields the API has no equivalent for are rejected instead
+// of being silently ignored.
+func imageBuildOptions(service types.ServiceConfig, imageName string) (build.ImageBuildOptions, error) {
+	cfg := service.Build
 
-	if imageName == "" {
-		return "", "", fmt.Errorf("imageName is empty")
+	var unsupported []string
+	if len(cfg.CacheTo) > 0 {
+		unsupported = append(unsupported, "cache_to")
 	}
-
-	tmpContextPath, err := os.MkdirTemp("", "stackr-build-context-"+imageName+"-"+sid+"-")
-	if err != nil {
-		return "", "", fmt.Errorf("failed to create temporary build context directory: %w", err)
+	if len(cfg.SSH) > 0 {
+		unsupported = append(unsupported, "ssh")
+	}
+	if len(cfg.Secrets) > 0 {
+		unsupported = append(unsupported, "secrets")
+	}
+	if cfg.Privileged {
+		unsupported = append(unsupported, "privileged")
+	}
+	if len(cfg.Platforms) > 1 {
+		unsupported = append(unsupported, "platforms (more than one)")
+	}
+	if len(unsupported) > 0 {
+		return build.ImageBuildOptions{}, fmt.Errorf("build options not supported by the docker build API for service %s: %s", service.Name, strings.Join(unsupported, ", "))
 	}
 
-	err = copyDirectory(contextPath, tmpContextPath)
-	if err != nil {
-		return "", "", fmt.Errorf("failed to copy build context from '%s' to temporary directory '%s': %w", contextPath, tmpContextPath, err)
+	dockerBuildArgs := make(map[string]*string)
+	if cfg.Args != nil {
+		for k, v := range cfg.Args {
+			val := v
+			dockerBuildArgs[k] = val
+		}
 	}
 
-	dockerfilePath := "Dockerfile" // Default name for the inline Dockerfile within the temp context
-	err = os.WriteFile(filepath.Join(tmpContextPath, dockerfilePath), []byte(inlineDockerFile), 0644)
-	if err != nil {
-		return "", "", fmt.Errorf("failed to write inline Dockerfile to temporary file '%s': %w", filepath.Join(tmpContextPath, dockerfilePath), err)
+	tags := []string{imageName}
+	for _, tag := range cfg.Tags {
+		if tag != imageName {
+			tags = append(tags, tag)
+		}
 	}
 
-	fmt.Printf("Building image from inline Dockerfile for service: %s (using temporary context: %s)\n", serviceName, tmpContextPath)
+	opts := build.ImageBuildOptions{
+		Tags:        tags,
+		Remove:      true,
+		BuildArgs:   dockerBuildArgs,
+		Target:      cfg.Target,
+		CacheFrom:   cfg.CacheFrom,
+		Labels:      cfg.Labels,
+		NetworkMode: cfg.Network,
+		ExtraHosts:  composeconvert.TranslateExtraHosts(cfg.ExtraHosts),
+		NoCache:     cfg.NoCache,
+		PullParent:  cfg.Pull,
+		Isolation:   container.Isolation(cfg.Isolation),
+		Ulimits:     composeconvert.TranslateUlimits(cfg.Ulimits),
+	}
+	if len(cfg.Platforms) == 1 {
+		opts.Platform = cfg.Platforms[0]
+	} else if service.Platform != "" {
+		opts.Platform = service.Platform
+	}
+	if size, ok := cfg.Extensions[composeconvert.BuildShmSizeExtension].(int64); ok {
+		opts.ShmSize = size
+	}
 
-	return tmpContextPath, dockerfilePath, nil
+	return opts, nil
 }
 
-// cleanUpTempDir ensures the temporary directory is removed regardless of build success or failure.
-func cleanUpTempDir(tmpContextPath string) {
-	if _, statErr := os.Stat(tmpContextPath); statErr == nil || !os.IsNotExist(statErr) {
-		os.RemoveAll(tmpContextPath)
-	}
+// isRemoteContext reports whether the build context is a git repository, an
+// image or a URL rather than a local directory.
+func isRemoteContext(contextPath string) bool {
+	return strings.Contains(contextPath, "://") || strings.HasPrefix(contextPath, "git@")
 }
 
-// copyDirectory recursively copies a directory from src to dst.
-func copyDirectory(src string, dst string) error {
-	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		relPath, err := filepath.Rel(src, path)
-		if err != nil {
-			return err
-		}
-
-		destPath := filepath.Join(dst, relPath)
-
-		if info.IsDir() {
-			return os.MkdirAll(destPath, info.Mode())
-		}
+// resolveDockerfile returns the Dockerfile path relative to the build context.
+// When the Dockerfile lives outside of the context its content is returned
+// instead, so it can be added to the context sent to the daemon.
+func resolveDockerfile(contextPath, dockerfile string) (string, string, error) {
+	absPath := dockerfile
+	if !filepath.IsAbs(absPath) {
+		absPath = filepath.Join(contextPath, dockerfile)
+	}
 
-		if path == src && !info.IsDir() {
-			return nil
-		}
+	relPath, err := filepath.Rel(contextPath, absPath)
+	if err == nil && relPath != ".." && !strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		return filepath.ToSlash(relPath), "", nil
+	}
 
-		srcFile, err := os.Open(path)
-		if err != nil {
-			return err
-		}
-		defer srcFile.Close()
+	content, err := os.ReadFile(absPath)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read Dockerfile '%s': %w", absPath, err)
+	}
+	return "", string(content), nil
+}
 
-		dstFile, err := os.Create(destPath)
-		if err != nil {
-			return err
+// addDockerfileToContext adds the Dockerfile to the build context under name
+// and lists it in the context's .dockerignore, so the daemon drops it again
+// before building and `COPY . .` doesn't pick it up. The docker CLI does the
+// same for a Dockerfile read from stdin.
+func addDockerfileToContext(buildCtx io.ReadCloser, name string, content []byte) io.ReadCloser {
+	now := time.Now()
+	header := func(name string) *tar.Header {
+		return &tar.Header{
+			Name:       name,
+			Mode:       0600,
+			ModTime:    now,
+			AccessTime: now,
+			ChangeTime: now,
+			Typeflag:   tar.TypeReg,
 		}
-		defer dstFile.Close()
-
-		_, err = io.Copy(dstFile, srcFile)
-		return err
+	}
+	return archive.ReplaceFileTarWrapper(buildCtx, map[string]archive.TarModifierFunc{
+		name: func(_ string, _ *tar.Header, _ io.Reader) (*tar.Header, []byte, error) {
+			return header(name), content, nil
+		},
+		".dockerignore": func(_ string, h *tar.Header, r io.Reader) (*tar.Header, []byte, error) {
+			var ignore []byte
+			if h == nil {
+				h = header(".dockerignore")
+			} else {
+				var err error
+				if ignore, err = io.ReadAll(r); err != nil {
+					return nil, nil, err
+				}
+			}
+			ignore = append(ignore, []byte("\n.dockerignore\n"+name+"\n")...)
+			return h, ignore, nil
+		},
 	})
 }
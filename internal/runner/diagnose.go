@@ -0,0 +1,82 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// defaultDiagnosticLogLines is how many of a failed dependency's last log
+// lines end up in the error when UpOptions doesn't say.
+const defaultDiagnosticLogLines = 20
+
+// diagnoseTimeout bounds the calls made to describe a failed dependency, which
+// happen after the wait's own context may have expired.
+const diagnoseTimeout = 5 * time.Second
+
+// diagnose describes the named container for an error message: its state and
+// exit code, its last health check results and the last logLines lines of its
+// logs. Whatever can't be read is left out.
+func diagnose(ctx context.Context, cli Engine, name string, logLines int) string {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), diagnoseTimeout)
+	defer cancel()
+
+	info, err := cli.ContainerInspect(ctx, name)
+	if err != nil || info.State == nil {
+		return fmt.Sprintf("\ncontainer %s: not found", name)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "\ncontainer %s: %s", name, info.State.Status)
+	if !info.State.Running && info.State.FinishedAt != "" {
+		fmt.Fprintf(&b, ", exit code %d", info.State.ExitCode)
+	}
+	if info.State.OOMKilled {
+		b.WriteString(", OOM killed")
+	}
+	if info.State.Health != nil {
+		fmt.Fprintf(&b, ", health %s", info.State.Health.Status)
+		if len(info.State.Health.Log) > 0 {
+			b.WriteString("\nlast health checks:")
+			for _, check := range info.State.Health.Log {
+				fmt.Fprintf(&b, "\n  %s exit %d: %s", check.End.Format(time.RFC3339), check.ExitCode, strings.TrimSpace(check.Output))
+			}
+		}
+	}
+
+	if logLines <= 0 {
+		return b.String()
+	}
+	reader, err := cli.ContainerLogs(ctx, info.ID, container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Tail:       strconv.Itoa(logLines),
+	})
+	if err != nil {
+		return b.String()
+	}
+	defer reader.Close()
+
+	var logs bytes.Buffer
+	if info.Config != nil && info.Config.Tty {
+		_, err = io.Copy(&logs, reader)
+	} else {
+		_, err = stdcopy.StdCopy(&logs, &logs, reader)
+	}
+	if err != nil || logs.Len() == 0 {
+		return b.String()
+	}
+	lines := strings.Split(strings.TrimRight(logs.String(), "\n"), "\n")
+	fmt.Fprintf(&b, "\nlast %d log lines:", len(lines))
+	for _, line := range lines {
+		b.WriteString("\n  " + line)
+	}
+	return b.String()
+}
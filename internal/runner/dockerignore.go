@@ -0,0 +1,84 @@
+package runner
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/moby/patternmatcher"
+	"github.com/moby/patternmatcher/ignorefile"
+)
+
+// readDockerignore returns the exclude patterns for a build context, the way
+// docker does: a `<Dockerfile>.dockerignore` next to the Dockerfile wins over
+// the `.dockerignore` at the root of the context.
+func readDockerignore(contextPath, dockerfile string, inline bool) ([]string, error) {
+	var candidates []string
+	if !inline {
+		if dockerfile == "" {
+			dockerfile = "Dockerfile"
+		}
+		if !filepath.IsAbs(dockerfile) {
+			dockerfile = filepath.Join(contextPath, dockerfile)
+		}
+		candidates = append(candidates, dockerfile+".dockerignore")
+	}
+	candidates = append(candidates, filepath.Join(contextPath, ".dockerignore"))
+
+	for _, path := range candidates {
+		f, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to open '%s': %w", path, err)
+		}
+		defer f.Close()
+
+		patterns, err := ignorefile.ReadAll(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse '%s': %w", path, err)
+		}
+		return patterns, nil
+	}
+	return nil, nil
+}
+
+// trimBuildFilesFromExcludes makes sure the Dockerfile and .dockerignore are
+// still sent to the daemon when the ignore patterns would exclude them, the
+// daemon needs the former and the docker CLI does the same for the latter.
+// dockerfile is empty when the Dockerfile isn't part of the context.
+func trimBuildFilesFromExcludes(excludes []string, dockerfile string) ([]string, error) {
+	if len(excludes) == 0 {
+		return excludes, nil
+	}
+	for _, keep := range []string{".dockerignore", dockerfile} {
+		if keep == "" {
+			continue
+		}
+		matched, err := patternmatcher.MatchesOrParentMatches(keep, excludes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid .dockerignore pattern: %w", err)
+		}
+		if matched {
+			excludes = append(excludes, "!"+keep)
+		}
+	}
+	return excludes, nil
+}
+
+// countingReader counts the bytes read through it, used to report the size of
+// the build context sent to the daemon. The HTTP transport reads it from its
+// own goroutine, so n is atomic.
+type countingReader struct {
+	r io.Reader
+	n atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
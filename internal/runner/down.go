@@ -0,0 +1,180 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/JamesTiberiusKirk/go-docker-compose/internal/composeconvert"
+	"github.com/compose-spec/compose-go/types"
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
+)
+
+type DownOptions struct {
+	// Timeout overrides every service's stop_grace_period when set.
+	Timeout *time.Duration
+	// RemoveImages removes the images built for services with a build section.
+	RemoveImages bool
+	// RemoveVolumes removes anonymous volumes attached to the containers as well
+	// as the named volumes the runner created for the project.
+	RemoveVolumes bool
+	// RemoveNetworks removes the networks the runner created for the project.
+	RemoveNetworks bool
+	// RemoveOrphans also removes project containers whose service is no longer
+	// in the compose file.
+	RemoveOrphans bool
+	// Events receives a typed event for every step. Defaults to a console
+	// sink on stdout.
+	Events EventSink
+}
+
+// Down stops and removes the containers of the project in reverse dependency
+// order, then removes whatever else was asked for in opts. Resources are found
+// by their project label, so anything not created by the runner is left alone.
+// It keeps going after a failure so one stuck resource doesn't leave the rest
+// behind, and returns all the errors it hit joined together.
+func Down(ctx context.Context, cli Engine, stackConfig *types.Project, opts DownOptions) error {
+	events := newEmitter(opts.Events)
+	err := down(ctx, cli, events, stackConfig, opts)
+	if err != nil {
+		events.emit(Event{Type: EventError, Err: err})
+	}
+	return err
+}
+
+func down(ctx context.Context, cli Engine, events *emitter, stackConfig *types.Project, opts DownOptions) error {
+	ordered, err := composeconvert.OrderServices(stackConfig.Services)
+	if err != nil {
+		return fmt.Errorf("failed to order services: %w", err)
+	}
+
+	containers, err := Ps(ctx, cli, stackConfig)
+	if err != nil {
+		return err
+	}
+	byService := map[string][]container.Summary{}
+	for _, c := range containers {
+		name := c.Labels[ServiceLabel]
+		byService[name] = append(byService[name], c)
+	}
+
+	var errs []error
+	for i := len(ordered) - 1; i >= 0; i-- {
+		service := ordered[i]
+		for _, c := range byService[service.Name] {
+			if err := removeContainer(ctx, cli, events, service.Name, c, stopTimeout(service, opts.Timeout), opts.RemoveVolumes); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		delete(byService, service.Name)
+	}
+
+	if opts.RemoveOrphans {
+		orphans := make([]string, 0, len(byService))
+		for name := range byService {
+			orphans = append(orphans, name)
+		}
+		sort.Strings(orphans)
+		for _, name := range orphans {
+			for _, c := range byService[name] {
+				if err := removeContainer(ctx, cli, events, name, c, stopTimeout(types.ServiceConfig{}, opts.Timeout), opts.RemoveVolumes); err != nil {
+					errs = append(errs, err)
+				}
+			}
+		}
+	}
+
+	if opts.RemoveImages {
+		for _, service := range ordered {
+			if service.Build == nil {
+				continue
+			}
+			imageName := service.Image
+			if imageName == "" {
+				imageName = service.Name
+			}
+			for _, ref := range append([]string{imageName}, service.Build.Tags...) {
+				_, err := cli.ImageRemove(ctx, ref, image.RemoveOptions{Force: true, PruneChildren: true})
+				if err != nil && !cerrdefs.IsNotFound(err) {
+					errs = append(errs, fmt.Errorf("remove image %s: %w", ref, err))
+				} else if err == nil {
+					events.emit(Event{Type: EventImageRemoved, Service: service.Name, Image: ref, Message: "Removed image " + ref})
+				}
+			}
+		}
+	}
+
+	if opts.RemoveVolumes {
+		vols, err := cli.VolumeList(ctx, volume.ListOptions{Filters: projectFilter(stackConfig)})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("list volumes for project %s: %w", stackConfig.Name, err))
+		}
+		for _, vol := range vols.Volumes {
+			err := cli.VolumeRemove(ctx, vol.Name, true)
+			if err != nil && !cerrdefs.IsNotFound(err) {
+				errs = append(errs, fmt.Errorf("remove volume %s: %w", vol.Name, err))
+			} else if err == nil {
+				events.emit(Event{Type: EventVolumeRemoved, Volume: vol.Name, Message: "Removed volume " + vol.Name})
+			}
+		}
+	}
+
+	if opts.RemoveNetworks {
+		nets, err := cli.NetworkList(ctx, network.ListOptions{Filters: projectFilter(stackConfig)})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("list networks for project %s: %w", stackConfig.Name, err))
+		}
+		for _, nw := range nets {
+			err := cli.NetworkRemove(ctx, nw.ID)
+			if err != nil && !cerrdefs.IsNotFound(err) {
+				errs = append(errs, fmt.Errorf("remove network %s: %w", nw.Name, err))
+			} else if err == nil {
+				events.emit(Event{Type: EventNetworkRemoved, Network: nw.Name, Message: "Removed network " + nw.Name})
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func removeContainer(ctx context.Context, cli Engine, events *emitter, serviceName string, c container.Summary, timeout *int, removeVolumes bool) error {
+	if c.State == container.StateRunning {
+		events.emit(Event{Type: EventContainerStopping, Service: serviceName, ContainerID: c.ID, Message: "Stopping container " + serviceName})
+		if err := cli.ContainerStop(ctx, c.ID, container.StopOptions{Timeout: timeout}); err != nil && !cerrdefs.IsNotFound(err) {
+			return fmt.Errorf("stop container %s: %w", serviceName, err)
+		}
+	}
+
+	err := cli.ContainerRemove(ctx, c.ID, container.RemoveOptions{
+		Force:         true,
+		RemoveVolumes: removeVolumes,
+	})
+	if err != nil && !cerrdefs.IsNotFound(err) {
+		return fmt.Errorf("remove container %s: %w", serviceName, err)
+	}
+	events.emit(Event{Type: EventContainerRemoved, Service: serviceName, ContainerID: c.ID, Message: "Removed container " + serviceName})
+	return nil
+}
+
+// stopTimeout returns the number of seconds docker should wait before killing
+// the container, or nil to use the daemon default. Docker only takes whole
+// seconds, so a grace period is rounded up rather than cut short.
+func stopTimeout(service types.ServiceConfig, override *time.Duration) *int {
+	var d time.Duration
+	switch {
+	case override != nil:
+		d = *override
+	case service.StopGracePeriod != nil:
+		d = time.Duration(*service.StopGracePeriod)
+	default:
+		return nil
+	}
+	secs := composeconvert.StopSeconds(d)
+	return &secs
+}
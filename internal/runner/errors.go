@@ -0,0 +1,46 @@
+package runner
+
+import "fmt"
+
+// DependencyFailure says why a dependency can no longer meet its depends_on
+// condition.
+type DependencyFailure string
+
+const (
+	// DependencyExited means the container stopped, for
+	// service_completed_successfully with a non-zero exit code.
+	DependencyExited DependencyFailure = "exited"
+	// DependencyOOMKilled means the container was killed for running out of
+	// memory.
+	DependencyOOMKilled DependencyFailure = "oom_killed"
+	// DependencyUnhealthy means the container's healthcheck reported it
+	// unhealthy.
+	DependencyUnhealthy DependencyFailure = "unhealthy"
+)
+
+// DependencyFailedError ends the wait on a dependency as soon as it can no
+// longer meet its condition, rather than when the wait times out. A timeout
+// wraps context.DeadlineExceeded instead, so callers can tell the two apart
+// with errors.As and errors.Is.
+type DependencyFailedError struct {
+	// Service is the service that was waiting.
+	Service    string
+	Dependency string
+	Condition  string
+	Reason     DependencyFailure
+	// ExitCode is the dependency's exit code when it exited or was
+	// OOM-killed.
+	ExitCode int
+}
+
+func (e *DependencyFailedError) Error() string {
+	switch e.Reason {
+	case DependencyExited:
+		return fmt.Sprintf("%s exited with code %d", e.Dependency, e.ExitCode)
+	case DependencyOOMKilled:
+		return fmt.Sprintf("%s was OOM-killed (exit code %d)", e.Dependency, e.ExitCode)
+	case DependencyUnhealthy:
+		return fmt.Sprintf("%s is unhealthy", e.Dependency)
+	}
+	return fmt.Sprintf("%s failed: %s", e.Dependency, e.Reason)
+}
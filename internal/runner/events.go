@@ -0,0 +1,295 @@
+package runner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/moby/term"
+)
+
+// EventType says which step of Up, Down or Restart an Event reports.
+type EventType string
+
+const (
+	// EventServiceQueued is emitted for every service, in dependency order,
+	// before Up starts working on any of them.
+	EventServiceQueued EventType = "ServiceQueued"
+	// EventServiceDone is emitted once Up is done with a service, whether it
+	// came up, failed or was cancelled.
+	EventServiceDone         EventType = "ServiceDone"
+	EventServicePreparing    EventType = "ServicePreparing"
+	EventDependencyWaiting   EventType = "DependencyWaiting"
+	EventDependencySatisfied EventType = "DependencySatisfied"
+	EventImageLocal          EventType = "ImageLocal"
+	EventPullStarted         EventType = "PullStarted"
+	EventPullProgress        EventType = "PullProgress"
+	EventPullComplete        EventType = "PullComplete"
+	EventBuildLog            EventType = "BuildLog"
+	EventImageBuilt          EventType = "ImageBuilt"
+	EventContainerRecreating EventType = "ContainerRecreating"
+	EventContainerUpToDate   EventType = "ContainerUpToDate"
+	EventContainerCreated    EventType = "ContainerCreated"
+	EventContainerStarted    EventType = "ContainerStarted"
+	EventContainerRestarted  EventType = "ContainerRestarted"
+	EventContainerStopping   EventType = "ContainerStopping"
+	EventContainerRemoved    EventType = "ContainerRemoved"
+	EventNetworkCreated      EventType = "NetworkCreated"
+	EventNetworkRemoved      EventType = "NetworkRemoved"
+	EventVolumeCreated       EventType = "VolumeCreated"
+	EventVolumeRemoved       EventType = "VolumeRemoved"
+	EventImageRemoved        EventType = "ImageRemoved"
+	// EventInfo carries a message that isn't tied to one of the steps above.
+	EventInfo  EventType = "Info"
+	EventError EventType = "Error"
+)
+
+// Event is one step of Up or Down. Only the fields that apply to the type
+// are set.
+type Event struct {
+	Type EventType `json:"type"`
+	Time time.Time `json:"time"`
+	// Service is the service the event belongs to, empty for project wide
+	// resources like networks and volumes.
+	Service     string `json:"service,omitempty"`
+	ContainerID string `json:"container_id,omitempty"`
+	Image       string `json:"image,omitempty"`
+	Network     string `json:"network,omitempty"`
+	Volume      string `json:"volume,omitempty"`
+	// Dependency and Condition are set on the dependency events.
+	Dependency string `json:"dependency,omitempty"`
+	Condition  string `json:"condition,omitempty"`
+	// Layers, LayersDone, Current and Total are the aggregated progress of a
+	// pull, in layers and bytes.
+	Layers     int   `json:"layers,omitempty"`
+	LayersDone int   `json:"layers_done,omitempty"`
+	Current    int64 `json:"current,omitempty"`
+	Total      int64 `json:"total,omitempty"`
+	// Message is the human readable line the console prints for the event.
+	Message string `json:"message,omitempty"`
+	Err     error  `json:"-"`
+}
+
+// MarshalJSON adds Err as the "error" string.
+func (e Event) MarshalJSON() ([]byte, error) {
+	type plain Event
+	out := struct {
+		plain
+		Error string `json:"error,omitempty"`
+	}{plain: plain(e)}
+	if e.Err != nil {
+		out.Error = e.Err.Error()
+	}
+	return json.Marshal(out)
+}
+
+// EventSink receives the events of Up and Down. Within a single call Handle
+// is never called concurrently, but calls running at the same time and
+// sharing a sink do call it concurrently. The sinks of this package lock.
+type EventSink interface {
+	Handle(Event)
+}
+
+// EventSinkFunc lets a plain function be used as an EventSink.
+type EventSinkFunc func(Event)
+
+func (f EventSinkFunc) Handle(e Event) {
+	f(e)
+}
+
+// MultiSink passes every event to each of the sinks in turn.
+func MultiSink(sinks ...EventSink) EventSink {
+	return EventSinkFunc(func(e Event) {
+		for _, sink := range sinks {
+			sink.Handle(e)
+		}
+	})
+}
+
+// NewJSONSink writes every event to w as one JSON object per line.
+func NewJSONSink(w io.Writer) EventSink {
+	var mu sync.Mutex
+	enc := json.NewEncoder(w)
+	return EventSinkFunc(func(e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		_ = enc.Encode(e)
+	})
+}
+
+// consoleSink prints the event messages for humans, grouped per service so
+// services running concurrently don't interleave. Pull progress is only
+// drawn when writing to a terminal, one line per service pulling, kept below
+// the other output and redrawn in place.
+type consoleSink struct {
+	mu     sync.Mutex
+	w      io.Writer
+	tty    bool
+	groups *serviceGroups
+	// progress holds the last progress message of every service still
+	// pulling, in the order they started.
+	progress      map[string]string
+	progressOrder []string
+	// drawn is the number of progress lines on screen.
+	drawn int
+}
+
+// NewConsoleSink prints the events' messages to w. With tty set pull progress
+// is redrawn in place, otherwise only the summary of each pull is printed.
+func NewConsoleSink(w io.Writer, tty bool) EventSink {
+	c := &consoleSink{w: w, tty: tty, progress: map[string]string{}}
+	c.groups = newServiceGroups(c.println)
+	return c
+}
+
+func (c *consoleSink) Handle(e Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer c.drawProgress()
+
+	if e.Type == EventPullProgress {
+		if c.tty {
+			c.setProgress(e.Service, e.Message)
+		}
+		return
+	}
+	// Whatever the service does after pulling ends its progress line.
+	c.setProgress(e.Service, "")
+
+	switch e.Type {
+	case EventServiceQueued:
+		c.groups.queue(e.Service)
+		return
+	case EventServiceDone:
+		c.groups.finish(e.Service)
+		return
+	}
+
+	msg := e.Message
+	if e.Type == EventBuildLog || e.Type == EventPullComplete {
+		msg = e.Service + ": " + msg
+	}
+	if e.Type == EventError && e.Err != nil {
+		msg = e.Err.Error()
+		if e.Service != "" {
+			msg = "Service " + e.Service + " failed: " + msg
+		}
+	}
+	if msg == "" {
+		return
+	}
+	c.groups.line(e.Service, strings.TrimRight(msg, "\n"))
+}
+
+// setProgress replaces the service's progress line, an empty message
+// removes it. The lines on screen are cleared, drawProgress draws them again.
+func (c *consoleSink) setProgress(service, msg string) {
+	old, shown := c.progress[service]
+	if old == msg || (!shown && msg == "") {
+		return
+	}
+	c.clearProgress()
+	switch {
+	case msg == "":
+		delete(c.progress, service)
+		c.progressOrder = slices.DeleteFunc(c.progressOrder, func(s string) bool { return s == service })
+	case !shown:
+		c.progressOrder = append(c.progressOrder, service)
+		fallthrough
+	default:
+		c.progress[service] = msg
+	}
+}
+
+// clearProgress erases the progress lines from the screen.
+func (c *consoleSink) clearProgress() {
+	if c.drawn > 0 {
+		fmt.Fprintf(c.w, "\033[%dA\033[J", c.drawn)
+		c.drawn = 0
+	}
+}
+
+// drawProgress draws the progress lines below the output unless they are
+// still on screen.
+func (c *consoleSink) drawProgress() {
+	if c.drawn > 0 {
+		return
+	}
+	for _, service := range c.progressOrder {
+		fmt.Fprintf(c.w, "%s: %s\n", service, c.progress[service])
+	}
+	c.drawn = len(c.progressOrder)
+}
+
+// println writes a line above the progress lines.
+func (c *consoleSink) println(line string) {
+	c.clearProgress()
+	fmt.Fprintln(c.w, line)
+}
+
+// defaultSink is the console on stdout, used when no sink is given.
+func defaultSink() EventSink {
+	return NewConsoleSink(os.Stdout, term.IsTerminal(os.Stdout.Fd()))
+}
+
+// emitter stamps events and hands them to the sink one at a time.
+type emitter struct {
+	mu   sync.Mutex
+	sink EventSink
+}
+
+func newEmitter(sink EventSink) *emitter {
+	if sink == nil {
+		sink = defaultSink()
+	}
+	return &emitter{sink: sink}
+}
+
+func (e *emitter) emit(evt Event) {
+	if evt.Time.IsZero() {
+		evt.Time = time.Now()
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.sink.Handle(evt)
+}
+
+// emitf emits an event of the given type for a service with a formatted
+// message.
+func (e *emitter) emitf(typ EventType, service, format string, args ...any) {
+	e.emit(Event{Type: typ, Service: service, Message: fmt.Sprintf(format, args...)})
+}
+
+// lineWriter turns what's written to it into one BuildLog event per line.
+type lineWriter struct {
+	events  *emitter
+	service string
+	buf     []byte
+}
+
+func (l *lineWriter) Write(p []byte) (int, error) {
+	l.buf = append(l.buf, p...)
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		if i < 0 {
+			break
+		}
+		l.events.emit(Event{Type: EventBuildLog, Service: l.service, Message: string(l.buf[:i])})
+		l.buf = l.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// flush emits whatever is left after the last newline.
+func (l *lineWriter) flush() {
+	if len(l.buf) > 0 {
+		l.events.emit(Event{Type: EventBuildLog, Service: l.service, Message: string(l.buf)})
+		l.buf = nil
+	}
+}
@@ -0,0 +1,276 @@
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/JamesTiberiusKirk/go-docker-compose/internal/composeconvert"
+	"github.com/JamesTiberiusKirk/go-docker-compose/internal/fakeengine"
+	"github.com/compose-spec/compose-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_Events(t *testing.T) {
+	project := loadProject(t, `
+services:
+  app:
+    image: busybox:latest
+    depends_on: [db]
+  db:
+    image: alpine:latest
+`)
+
+	var events []Event
+	var jsonOut bytes.Buffer
+	sink := MultiSink(EventSinkFunc(func(e Event) { events = append(events, e) }), NewJSONSink(&jsonOut))
+	require.NoError(t, Up(t.Context(), fakeengine.New(), project, UpOptions{Events: sink}))
+
+	var appTypes []EventType
+	for _, e := range events {
+		assert.False(t, e.Time.IsZero(), "event %s has no timestamp", e.Type)
+		if e.Service == "app" {
+			appTypes = append(appTypes, e.Type)
+		}
+	}
+	assert.Equal(t, []EventType{
+		EventServiceQueued,
+		EventDependencyWaiting,
+		EventDependencySatisfied,
+		EventServicePreparing,
+		EventPullStarted,
+		EventPullComplete,
+		EventContainerCreated,
+		EventContainerStarted,
+		EventServiceDone,
+	}, appTypes)
+
+	// Every JSON line decodes back into the event it came from.
+	scanner := bufio.NewScanner(&jsonOut)
+	i := 0
+	for scanner.Scan() {
+		var decoded Event
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &decoded))
+		assert.Equal(t, events[i].Type, decoded.Type)
+		assert.Equal(t, events[i].Service, decoded.Service)
+		assert.Equal(t, events[i].Message, decoded.Message)
+		i++
+	}
+	assert.Equal(t, len(events), i)
+}
+
+func TestUp_ErrorEvent(t *testing.T) {
+	project := loadProject(t, `
+services:
+  db:
+    image: alpine:latest
+`)
+	e := fakeengine.New()
+	e.SetBehavior("db", fakeengine.Behavior{StartErr: errors.New("port is already allocated")})
+
+	var jsonOut bytes.Buffer
+	require.Error(t, Up(t.Context(), e, project, UpOptions{Events: NewJSONSink(&jsonOut)}))
+
+	// The error is the last event before the service is done.
+	lines := strings.Split(strings.TrimSpace(jsonOut.String()), "\n")
+	require.GreaterOrEqual(t, len(lines), 2)
+	var failed, done map[string]any
+	require.NoError(t, json.Unmarshal([]byte(lines[len(lines)-2]), &failed))
+	require.NoError(t, json.Unmarshal([]byte(lines[len(lines)-1]), &done))
+	assert.Equal(t, "Error", failed["type"])
+	assert.Equal(t, "db", failed["service"])
+	assert.Contains(t, failed["error"], "port is already allocated")
+	assert.Equal(t, "ServiceDone", done["type"])
+	assert.Equal(t, "db", done["service"])
+}
+
+func TestUp_ConsoleGroupsConcurrentServices(t *testing.T) {
+	project := loadProject(t, `
+services:
+  app:
+    image: busybox:latest
+    depends_on:
+      db:
+        condition: service_healthy
+  cache:
+    image: redis:7
+  db:
+    image: postgres:16
+    healthcheck:
+      test: ["CMD", "true"]
+  queue:
+    image: rabbitmq:3
+`)
+	e := fakeengine.New()
+	e.SetBehavior("db", fakeengine.Behavior{HealthInterval: 20 * time.Millisecond})
+
+	var events []Event
+	var out bytes.Buffer
+	sink := MultiSink(EventSinkFunc(func(e Event) { events = append(events, e) }), NewConsoleSink(&out, false))
+	require.NoError(t, Up(t.Context(), e, project, UpOptions{Events: sink}))
+
+	// The console holds every service's lines together, in the order the
+	// services were queued, whatever order the events came in.
+	var queued []string
+	lines := map[string]string{}
+	var projectLines string
+	for _, e := range events {
+		if e.Type == EventServiceQueued {
+			queued = append(queued, e.Service)
+		}
+		var rendered bytes.Buffer
+		NewConsoleSink(&rendered, false).Handle(e)
+		if e.Service == "" {
+			projectLines += rendered.String()
+		} else {
+			lines[e.Service] += rendered.String()
+		}
+	}
+	require.Len(t, queued, 4)
+	assert.Less(t, indexOf(queued, "db"), indexOf(queued, "app"), "app is queued after its dependency")
+
+	expect := projectLines
+	for _, service := range queued {
+		expect += lines[service]
+	}
+	assert.Equal(t, expect, out.String())
+}
+
+func TestConsoleSink(t *testing.T) {
+	tests := []struct {
+		name   string
+		tty    bool
+		events []Event
+		expect string
+	}{
+		{
+			name: "Messages_and_pull_summary",
+			events: []Event{
+				{Type: EventServicePreparing, Service: "db", Message: "Preparing service: db"},
+				{Type: EventPullProgress, Service: "db", Message: "0/1 layers"},
+				{Type: EventPullComplete, Service: "db", Message: "Pull complete (1/1 layers, 1kB) in 1s"},
+				{Type: EventBuildLog, Service: "db", Message: "Step 1/2 : FROM alpine\n"},
+				{Type: EventError, Service: "db", Err: errors.New("boom")},
+			},
+			expect: "Preparing service: db\n" +
+				"db: Pull complete (1/1 layers, 1kB) in 1s\n" +
+				"db: Step 1/2 : FROM alpine\n" +
+				"Service db failed: boom\n",
+		},
+		{
+			name: "Tty_redraws_progress_in_place",
+			tty:  true,
+			events: []Event{
+				{Type: EventPullProgress, Service: "db", Message: "0/1 layers"},
+				{Type: EventPullProgress, Service: "db", Message: "1/1 layers"},
+				{Type: EventContainerStarted, Service: "db", Message: "Started container db"},
+			},
+			expect: "db: 0/1 layers\n" +
+				"\033[1A\033[Jdb: 1/1 layers\n" +
+				"\033[1A\033[JStarted container db\n",
+		},
+		{
+			name: "Tty_keeps_a_progress_line_per_service",
+			tty:  true,
+			events: []Event{
+				{Type: EventPullProgress, Service: "db", Message: "0/2 layers"},
+				{Type: EventPullProgress, Service: "cache", Message: "0/1 layers"},
+				{Type: EventPullProgress, Service: "db", Message: "1/2 layers"},
+				{Type: EventPullComplete, Service: "cache", Message: "Pull complete"},
+			},
+			expect: "db: 0/2 layers\n" +
+				"\033[1A\033[Jdb: 0/2 layers\ncache: 0/1 layers\n" +
+				"\033[2A\033[Jdb: 1/2 layers\ncache: 0/1 layers\n" +
+				"\033[2A\033[Jcache: Pull complete\ndb: 1/2 layers\n",
+		},
+		{
+			name: "Groups_lines_per_service",
+			events: []Event{
+				{Type: EventServiceQueued, Service: "db"},
+				{Type: EventServiceQueued, Service: "cache"},
+				{Type: EventServiceQueued, Service: "app"},
+				{Type: EventServicePreparing, Service: "cache", Message: "Preparing service: cache"},
+				{Type: EventServicePreparing, Service: "db", Message: "Preparing service: db"},
+				{Type: EventNetworkCreated, Network: "unit_default", Message: "Created network unit_default"},
+				{Type: EventContainerStarted, Service: "cache", Message: "Started container cache"},
+				{Type: EventServiceDone, Service: "cache"},
+				{Type: EventDependencyWaiting, Service: "app", Message: "Service app waiting for db"},
+				{Type: EventContainerStarted, Service: "db", Message: "Started container db"},
+				{Type: EventServiceDone, Service: "db"},
+				{Type: EventContainerStarted, Service: "app", Message: "Started container app"},
+				{Type: EventServiceDone, Service: "app"},
+			},
+			// db streams as the first service, cache and app are held back
+			// until the services before them are done.
+			expect: "Preparing service: db\n" +
+				"Created network unit_default\n" +
+				"Started container db\n" +
+				"Preparing service: cache\n" +
+				"Started container cache\n" +
+				"Service app waiting for db\n" +
+				"Started container app\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			sink := NewConsoleSink(&out, tt.tty)
+			for _, e := range tt.events {
+				e.Time = time.Now()
+				sink.Handle(e)
+			}
+			assert.Equal(t, tt.expect, out.String())
+		})
+	}
+}
+
+func TestSinks_SharedAcrossCalls(t *testing.T) {
+	var console, jsonOut bytes.Buffer
+	sink := MultiSink(NewConsoleSink(&console, true), NewJSONSink(&jsonOut))
+
+	var projects []*types.Project
+	for _, name := range []string{"one", "two"} {
+		project, err := tryLoadProject(t, `
+services:
+  db:
+    image: postgres
+  app:
+    image: alpine
+    depends_on: [db]
+`, composeconvert.LoadComposeProjectOptions{ProjectName: name})
+		require.NoError(t, err)
+		projects = append(projects, project)
+	}
+
+	// Both calls hand their events to the same sink at the same time.
+	var wg sync.WaitGroup
+	errs := make([]error, len(projects))
+	for i, project := range projects {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = Up(t.Context(), fakeengine.New(), project, UpOptions{Events: sink})
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+
+	scanner := bufio.NewScanner(&jsonOut)
+	lines := 0
+	for scanner.Scan() {
+		var evt map[string]any
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &evt), scanner.Text())
+		lines++
+	}
+	assert.NotZero(t, lines)
+	assert.Equal(t, 2, strings.Count(console.String(), "Started container app"))
+}
@@ -0,0 +1,91 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/compose-spec/compose-go/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+type ExecOptions struct {
+	// Env adds KEY=value variables to the command's environment.
+	Env        []string
+	User       string
+	WorkingDir string
+	Privileged bool
+	// Tty allocates a pseudo terminal, stdout and stderr are then merged.
+	Tty bool
+	// Stdin is copied to the command when set.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Exec runs cmd in the service's running container, waits for it to finish
+// and returns its exit code.
+func Exec(ctx context.Context, cli Engine, stackConfig *types.Project, serviceName string, cmd []string, opts ExecOptions) (int, error) {
+	if len(cmd) == 0 {
+		return 0, fmt.Errorf("no command to exec in service %s", serviceName)
+	}
+	c, err := serviceContainer(ctx, cli, stackConfig, serviceName)
+	if err != nil {
+		return 0, err
+	}
+	if c == nil || c.State != container.StateRunning {
+		return 0, fmt.Errorf("service %s has no running container", serviceName)
+	}
+
+	stdout, stderr := opts.Stdout, opts.Stderr
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	if stderr == nil {
+		stderr = io.Discard
+	}
+
+	created, err := cli.ContainerExecCreate(ctx, c.ID, container.ExecOptions{
+		Cmd:          cmd,
+		Env:          opts.Env,
+		User:         opts.User,
+		WorkingDir:   opts.WorkingDir,
+		Privileged:   opts.Privileged,
+		Tty:          opts.Tty,
+		AttachStdin:  opts.Stdin != nil,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("create exec in container %s: %w", serviceName, err)
+	}
+
+	attach, err := cli.ContainerExecAttach(ctx, created.ID, container.ExecAttachOptions{Tty: opts.Tty})
+	if err != nil {
+		return 0, fmt.Errorf("attach exec in container %s: %w", serviceName, err)
+	}
+	defer attach.Close()
+
+	if opts.Stdin != nil {
+		go func() {
+			_, _ = io.Copy(attach.Conn, opts.Stdin)
+			_ = attach.CloseWrite()
+		}()
+	}
+
+	if opts.Tty {
+		_, err = io.Copy(stdout, attach.Reader)
+	} else {
+		_, err = stdcopy.StdCopy(stdout, stderr, attach.Reader)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("read exec output in container %s: %w", serviceName, err)
+	}
+
+	info, err := cli.ContainerExecInspect(ctx, created.ID)
+	if err != nil {
+		return 0, fmt.Errorf("inspect exec in container %s: %w", serviceName, err)
+	}
+	return info.ExitCode, nil
+}
@@ -0,0 +1,104 @@
+package runner
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/compose-spec/compose-go/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+)
+
+// Labels stamped on everything the runner creates. They use the same keys as
+// docker compose so the docker CLI groups our resources the same way.
+const (
+	ProjectLabel     = "com.docker.compose.project"
+	ServiceLabel     = "com.docker.compose.service"
+	ConfigHashLabel  = "com.docker.compose.config-hash"
+	WorkingDirLabel  = "com.docker.compose.project.working_dir"
+	ConfigFilesLabel = "com.docker.compose.project.config_files"
+	NetworkLabel     = "com.docker.compose.network"
+	VolumeLabel      = "com.docker.compose.volume"
+)
+
+// projectLabels returns the labels shared by every resource of the project.
+func projectLabels(stackConfig *types.Project) map[string]string {
+	return map[string]string{
+		ProjectLabel:     stackConfig.Name,
+		WorkingDirLabel:  stackConfig.WorkingDir,
+		ConfigFilesLabel: strings.Join(stackConfig.ComposeFiles, ","),
+	}
+}
+
+// serviceLabels merges the user defined labels with the project, service and
+// config-hash labels for the service's container.
+func serviceLabels(stackConfig *types.Project, service types.ServiceConfig, hash string) map[string]string {
+	labels := map[string]string{}
+	maps.Copy(labels, service.Labels)
+	maps.Copy(labels, service.CustomLabels)
+	maps.Copy(labels, projectLabels(stackConfig))
+	labels[ServiceLabel] = service.Name
+	labels[ConfigHashLabel] = hash
+	return labels
+}
+
+// configHash hashes the translated container config and the ID of the image
+// it runs, so a later run can tell whether an existing container still
+// matches the compose file and the image behind its tag.
+func configHash(config *container.Config, hostConfig *container.HostConfig, netConfig *network.NetworkingConfig, imageID string) (string, error) {
+	b, err := json.Marshal(struct {
+		Config     *container.Config
+		HostConfig *container.HostConfig
+		NetConfig  *network.NetworkingConfig
+		ImageID    string
+	}{config, hostConfig, netConfig, imageID})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func projectFilter(stackConfig *types.Project) filters.Args {
+	return filters.NewArgs(filters.Arg("label", ProjectLabel+"="+stackConfig.Name))
+}
+
+// Ps lists every container, running or not, that belongs to the project.
+func Ps(ctx context.Context, cli Engine, stackConfig *types.Project) ([]container.Summary, error) {
+	if stackConfig.Name == "" {
+		return nil, fmt.Errorf("project name must not be empty")
+	}
+	list, err := cli.ContainerList(ctx, container.ListOptions{All: true, Filters: projectFilter(stackConfig)})
+	if err != nil {
+		return nil, fmt.Errorf("list containers for project %s: %w", stackConfig.Name, err)
+	}
+	return list, nil
+}
+
+// serviceContainer returns the project's container for the service, or nil if
+// there isn't one. The container named after the service wins over one kept
+// under another name while Up replaces it.
+func serviceContainer(ctx context.Context, cli Engine, stackConfig *types.Project, serviceName string) (*container.Summary, error) {
+	args := projectFilter(stackConfig)
+	args.Add("label", ServiceLabel+"="+serviceName)
+	list, err := cli.ContainerList(ctx, container.ListOptions{All: true, Filters: args})
+	if err != nil {
+		return nil, fmt.Errorf("list containers for service %s: %w", serviceName, err)
+	}
+	if len(list) == 0 {
+		return nil, nil
+	}
+	for i := range list {
+		if slices.Contains(list[i].Names, "/"+serviceName) {
+			return &list[i], nil
+		}
+	}
+	return &list[0], nil
+}
@@ -0,0 +1,62 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/compose-spec/compose-go/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+type LogsOptions struct {
+	// Follow keeps streaming new output until ctx is cancelled.
+	Follow bool
+	// Tail limits the output to the last N lines, empty or "all" shows everything.
+	Tail string
+	// Since only shows logs after a timestamp or a relative duration like 10m.
+	Since string
+	// Timestamps prefixes every line with its timestamp.
+	Timestamps bool
+}
+
+// Logs writes the output of the service's container to stdout and stderr.
+func Logs(ctx context.Context, cli Engine, stackConfig *types.Project, serviceName string, stdout, stderr io.Writer, opts LogsOptions) error {
+	c, err := serviceContainer(ctx, cli, stackConfig, serviceName)
+	if err != nil {
+		return err
+	}
+	if c == nil {
+		return fmt.Errorf("no container for service %s", serviceName)
+	}
+
+	info, err := cli.ContainerInspect(ctx, c.ID)
+	if err != nil {
+		return fmt.Errorf("inspect container %s: %w", serviceName, err)
+	}
+
+	reader, err := cli.ContainerLogs(ctx, c.ID, container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     opts.Follow,
+		Tail:       opts.Tail,
+		Since:      opts.Since,
+		Timestamps: opts.Timestamps,
+	})
+	if err != nil {
+		return fmt.Errorf("logs of container %s: %w", serviceName, err)
+	}
+	defer reader.Close()
+
+	// Output of a container with a TTY isn't multiplexed.
+	if info.Config != nil && info.Config.Tty {
+		_, err = io.Copy(stdout, reader)
+	} else {
+		_, err = stdcopy.StdCopy(stdout, stderr, reader)
+	}
+	if err != nil && ctx.Err() == nil {
+		return fmt.Errorf("read logs of container %s: %w", serviceName, err)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"sort"
+
+	"github.com/compose-spec/compose-go/types"
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types/network"
+)
+
+// ensureNetworks creates the project networks used by at least one service.
+// Networks that already exist are reused and external ones must already exist.
+// The networks it creates are recorded in created.
+func ensureNetworks(ctx context.Context, cli Engine, events *emitter, stackConfig *types.Project, created *createdResources) error {
+	used := map[string]bool{}
+	for _, service := range stackConfig.Services {
+		for key := range service.Networks {
+			used[key] = true
+		}
+	}
+
+	keys := make([]string, 0, len(used))
+	for key := range used {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		nw, ok := stackConfig.Networks[key]
+		if !ok {
+			return fmt.Errorf("network %s is used by a service but not defined", key)
+		}
+
+		_, err := cli.NetworkInspect(ctx, nw.Name, network.InspectOptions{})
+		if err == nil {
+			continue
+		}
+		if !cerrdefs.IsNotFound(err) {
+			return fmt.Errorf("inspect network %s: %w", nw.Name, err)
+		}
+		if nw.External.External {
+			return fmt.Errorf("network %s declared as external, but could not be found", nw.Name)
+		}
+
+		if _, err := cli.NetworkCreate(ctx, nw.Name, networkCreateOptions(stackConfig, key, nw)); err != nil {
+			return fmt.Errorf("create network %s: %w", nw.Name, err)
+		}
+		created.addNetwork(nw.Name)
+		events.emit(Event{Type: EventNetworkCreated, Network: nw.Name, Message: "Created network " + nw.Name})
+	}
+	return nil
+}
+
+func networkCreateOptions(stackConfig *types.Project, key string, nw types.NetworkConfig) network.CreateOptions {
+	labels := map[string]string{}
+	maps.Copy(labels, nw.Labels)
+	maps.Copy(labels, projectLabels(stackConfig))
+	labels[NetworkLabel] = key
+
+	enableIPv6 := nw.EnableIPv6
+	opts := network.CreateOptions{
+		Driver:     nw.Driver,
+		Options:    nw.DriverOpts,
+		Internal:   nw.Internal,
+		Attachable: nw.Attachable,
+		EnableIPv6: &enableIPv6,
+		Labels:     labels,
+	}
+
+	if nw.Ipam.Driver != "" || len(nw.Ipam.Config) > 0 {
+		ipam := &network.IPAM{Driver: nw.Ipam.Driver}
+		for _, pool := range nw.Ipam.Config {
+			if pool == nil {
+				continue
+			}
+			ipam.Config = append(ipam.Config, network.IPAMConfig{
+				Subnet:     pool.Subnet,
+				IPRange:    pool.IPRange,
+				Gateway:    pool.Gateway,
+				AuxAddress: pool.AuxiliaryAddresses,
+			})
+		}
+		opts.IPAM = ipam
+	}
+
+	return opts
+}
+
+// splitNetworkConfig keeps only the primary network in the config used to
+// create the container and returns the endpoints of the other networks, which
+// get connected once the container exists. Not every daemon accepts more than
+// one network on create.
+func splitNetworkConfig(netConfig *network.NetworkingConfig, primary string) (*network.NetworkingConfig, map[string]*network.EndpointSettings) {
+	createConfig := &network.NetworkingConfig{EndpointsConfig: map[string]*network.EndpointSettings{}}
+	rest := map[string]*network.EndpointSettings{}
+	for name, endpoint := range netConfig.EndpointsConfig {
+		if name == primary {
+			createConfig.EndpointsConfig[name] = endpoint
+		} else {
+			rest[name] = endpoint
+		}
+	}
+	return createConfig, rest
+}
+
+// connectNetworks attaches the container to the secondary networks in the
+// service's priority order.
+func connectNetworks(ctx context.Context, cli Engine, containerID string, order []string, endpoints map[string]*network.EndpointSettings) error {
+	for _, name := range order {
+		endpoint, ok := endpoints[name]
+		if !ok {
+			continue
+		}
+		if err := cli.NetworkConnect(ctx, name, containerID, endpoint); err != nil {
+			return fmt.Errorf("connect to network %s: %w", name, err)
+		}
+	}
+	return nil
+}
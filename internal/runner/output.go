@@ -0,0 +1,71 @@
+package runner
+
+// serviceGroups keeps the output lines of services running concurrently from
+// interleaving. Services are queued in dependency order. The lines of the
+// first one that isn't done are written as they come, those of the services
+// after it are held back and written, in queue order, once every service
+// before them is done. The output reads the same from run to run and the
+// service at the head still streams live.
+type serviceGroups struct {
+	write   func(line string)
+	order   []string
+	index   map[string]int
+	head    int
+	pending map[string][]string
+	done    map[string]bool
+}
+
+func newServiceGroups(write func(line string)) *serviceGroups {
+	return &serviceGroups{
+		write:   write,
+		index:   map[string]int{},
+		pending: map[string][]string{},
+		done:    map[string]bool{},
+	}
+}
+
+// queue adds the service after the ones already queued. Queueing after every
+// earlier service is done starts a new group, e.g. for the next Up sharing
+// the sink.
+func (g *serviceGroups) queue(service string) {
+	if g.head == len(g.order) && len(g.order) > 0 {
+		*g = *newServiceGroups(g.write)
+	}
+	if _, ok := g.index[service]; ok {
+		return
+	}
+	g.index[service] = len(g.order)
+	g.order = append(g.order, service)
+}
+
+// line writes the service's line, or holds it back while an earlier service
+// isn't done. Lines of services that were never queued are written straight
+// away.
+func (g *serviceGroups) line(service, line string) {
+	i, ok := g.index[service]
+	if !ok || i <= g.head {
+		g.write(line)
+		return
+	}
+	g.pending[service] = append(g.pending[service], line)
+}
+
+// finish marks the service as done and writes out the held back lines of
+// every service no longer waiting on an earlier one.
+func (g *serviceGroups) finish(service string) {
+	if _, ok := g.index[service]; !ok {
+		return
+	}
+	g.done[service] = true
+	for g.head < len(g.order) && g.done[g.order[g.head]] {
+		g.head++
+		if g.head == len(g.order) {
+			break
+		}
+		next := g.order[g.head]
+		for _, line := range g.pending[next] {
+			g.write(line)
+		}
+		delete(g.pending, next)
+	}
+}
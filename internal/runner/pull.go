@@ -0,0 +1,175 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/JamesTiberiusKirk/go-docker-compose/internal/prettyprint"
+	"github.com/compose-spec/compose-go/types"
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/registry"
+)
+
+// Pull policies on top of the ones compose-go defines.
+const (
+	PullPolicyDaily  = "daily"
+	PullPolicyWeekly = "weekly"
+	// PullPolicyEveryPrefix is followed by a duration, e.g. every_12h.
+	PullPolicyEveryPrefix = "every_"
+)
+
+// ensureImage makes sure the service's image is available according to its
+// pull policy, pulling or building it as needed. UpOptions.PullPolicy, when
+// set, replaces the policy of every service. As there's nothing to build for
+// a service without a build section, it gets missing in place of build.
+func (r *upRun) ensureImage(ctx context.Context, service types.ServiceConfig) error {
+	cli := r.cli
+	policy := service.PullPolicy
+	if r.opts.PullPolicy != "" {
+		policy = r.opts.PullPolicy
+		if policy == types.PullPolicyBuild && service.Build == nil {
+			policy = types.PullPolicyMissing
+		}
+	}
+
+	imageName := service.Image
+	if imageName == "" && service.Build != nil {
+		imageName = service.Name
+	}
+
+	if policy == types.PullPolicyBuild {
+		if service.Build == nil {
+			return fmt.Errorf("service %s has pull_policy build but no build section", service.Name)
+		}
+		return r.buildServiceImage(ctx, service, imageName)
+	}
+
+	// A service with a build section and no explicit policy is always built,
+	// an explicit policy decides whether it's pulled, built or reused instead.
+	if service.Build != nil && policy == "" {
+		return r.buildServiceImage(ctx, service, imageName)
+	}
+
+	pull, err := shouldPull(ctx, cli, imageName, policy)
+	if err != nil {
+		return fmt.Errorf("service %s: %w", service.Name, err)
+	}
+	if !pull {
+		r.events.emit(Event{Type: EventImageLocal, Service: service.Name, Image: imageName, Message: "Using local image: " + imageName})
+		return nil
+	}
+
+	registryAuth, err := r.auth.EncodedForImage(ctx, imageName)
+	if err != nil {
+		return fmt.Errorf("service %s: registry auth for %s: %w", service.Name, imageName, err)
+	}
+
+	err = pullImage(ctx, cli, r.events, service.Name, imageName, registryAuth)
+	if err != nil && service.Build != nil {
+		r.events.emitf(EventInfo, service.Name, "Pulling %s failed, building it instead: %v", imageName, err)
+		return r.buildServiceImage(ctx, service, imageName)
+	}
+	return err
+}
+
+// shouldPull decides from the policy and the local image whether to pull.
+func shouldPull(ctx context.Context, cli Engine, imageName, policy string) (bool, error) {
+	if policy == types.PullPolicyAlways {
+		return true, nil
+	}
+
+	var maxAge time.Duration
+	switch {
+	case policy == "", policy == types.PullPolicyMissing, policy == types.PullPolicyIfNotPresent, policy == types.PullPolicyNever:
+	case policy == PullPolicyDaily:
+		maxAge = 24 * time.Hour
+	case policy == PullPolicyWeekly:
+		maxAge = 7 * 24 * time.Hour
+	case strings.HasPrefix(policy, PullPolicyEveryPrefix):
+		d, err := time.ParseDuration(strings.TrimPrefix(policy, PullPolicyEveryPrefix))
+		if err != nil || d <= 0 {
+			return false, fmt.Errorf("invalid pull_policy %q", policy)
+		}
+		maxAge = d
+	default:
+		return false, fmt.Errorf("invalid pull_policy %q", policy)
+	}
+
+	info, err := cli.ImageInspect(ctx, imageName)
+	if err != nil {
+		if !cerrdefs.IsNotFound(err) {
+			return false, fmt.Errorf("inspect image %s: %w", imageName, err)
+		}
+		if policy == types.PullPolicyNever {
+			return false, fmt.Errorf("image %s not found locally and pull_policy is never", imageName)
+		}
+		return true, nil
+	}
+
+	if maxAge > 0 {
+		lastPulled := info.Metadata.LastTagTime
+		return lastPulled.IsZero() || time.Since(lastPulled) > maxAge, nil
+	}
+	return false, nil
+}
+
+// pullImage pulls the image, registryAuth holds the encoded credentials for
+// its registry or is empty for anonymous pulls.
+func pullImage(ctx context.Context, cli Engine, events *emitter, serviceName, imageName, registryAuth string) error {
+	events.emit(Event{Type: EventPullStarted, Service: serviceName, Image: imageName, Message: "Pulling image: " + imageName})
+	reader, err := cli.ImagePull(ctx, imageName, image.PullOptions{RegistryAuth: registryAuth})
+	if err != nil {
+		return fmt.Errorf("pull image %s: %w", imageName, err)
+	}
+	defer reader.Close()
+
+	pullEvent := func(typ EventType, p prettyprint.PullProgress, msg string) Event {
+		return Event{
+			Type:       typ,
+			Service:    serviceName,
+			Image:      imageName,
+			Layers:     p.Layers,
+			LayersDone: p.LayersDone,
+			Current:    p.Current,
+			Total:      p.Total,
+			Message:    msg,
+		}
+	}
+	progress, err := prettyprint.ReadPullProgress(reader, prettyprint.ProgressInterval, func(p prettyprint.PullProgress) {
+		events.emit(pullEvent(EventPullProgress, p, p.String()))
+	})
+	if err != nil {
+		return fmt.Errorf("pull image %s: %w", imageName, err)
+	}
+	events.emit(pullEvent(EventPullComplete, progress, progress.Summary()))
+	return nil
+}
+
+// buildAuthConfigs returns the credentials of every known registry, asking
+// the credential helpers only once per run.
+func (r *upRun) buildAuthConfigs(ctx context.Context) (map[string]registry.AuthConfig, error) {
+	r.buildAuthOnce.Do(func() {
+		r.buildAuth, r.buildAuthErr = r.auth.All(ctx)
+	})
+	return r.buildAuth, r.buildAuthErr
+}
+
+// buildServiceImage builds the service's image with the credentials of every
+// known registry, so base images from private registries can be pulled.
+func (r *upRun) buildServiceImage(ctx context.Context, service types.ServiceConfig, imageName string) error {
+	authConfigs, err := r.buildAuthConfigs(ctx)
+	if err != nil {
+		return fmt.Errorf("registry auth for building service %s: %w", service.Name, err)
+	}
+	out := &lineWriter{events: r.events, service: service.Name}
+	err = buildImage(ctx, r.cli, out, r.stackConfig, service, authConfigs)
+	out.flush()
+	if err != nil {
+		return fmt.Errorf("error building new image for service %s: %w", service.Name, err)
+	}
+	r.events.emit(Event{Type: EventImageBuilt, Service: service.Name, Image: imageName, Message: "Built image: " + imageName})
+	return nil
+}
@@ -0,0 +1,122 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/JamesTiberiusKirk/go-docker-compose/internal/composeconvert"
+	"github.com/compose-spec/compose-go/types"
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types/container"
+)
+
+type RestartOptions struct {
+	// Timeout overrides every service's stop_grace_period when set.
+	Timeout *time.Duration
+	// WaitTimeout bounds how long a dependent waits for a restarted
+	// dependency's depends_on condition. Zero waits until ctx is done.
+	WaitTimeout time.Duration
+	// Events receives a typed event for every step. Defaults to a console
+	// sink on stdout.
+	Events EventSink
+}
+
+// Restart restarts the containers of the named services, or of every service
+// when none are named, in dependency order. Services that depend on a
+// restarted one with `restart: true` are restarted too, once that dependency
+// meets its depends_on condition again. Services without a container are
+// skipped.
+func Restart(ctx context.Context, cli Engine, stackConfig *types.Project, services []string, opts RestartOptions) error {
+	events := newEmitter(opts.Events)
+	err := restart(ctx, cli, events, stackConfig, services, opts)
+	if err != nil {
+		events.emit(Event{Type: EventError, Err: err})
+	}
+	return err
+}
+
+func restart(ctx context.Context, cli Engine, events *emitter, stackConfig *types.Project, services []string, opts RestartOptions) error {
+	ordered, err := composeconvert.OrderServices(stackConfig.Services)
+	if err != nil {
+		return fmt.Errorf("failed to order services: %w", err)
+	}
+
+	selected := map[string]bool{}
+	for _, name := range services {
+		if _, err := stackConfig.GetService(name); err != nil {
+			return fmt.Errorf("no service %s in project %s", name, stackConfig.Name)
+		}
+		selected[name] = true
+	}
+
+	watchCtx, stopWatching := context.WithCancel(ctx)
+	defer stopWatching()
+	watcher := watchContainers(watchCtx, cli, stackConfig)
+
+	wait := waitOptions{timeout: opts.WaitTimeout, logLines: defaultDiagnosticLogLines}
+	restarted := map[string]bool{}
+	for _, service := range ordered {
+		depNames := make([]string, 0, len(service.DependsOn))
+		for depName := range service.DependsOn {
+			depNames = append(depNames, depName)
+		}
+		sort.Strings(depNames)
+
+		want := len(services) == 0 || selected[service.Name]
+		for _, depName := range depNames {
+			if restarted[depName] && service.DependsOn[depName].Restart {
+				want = true
+			}
+		}
+		if !want {
+			continue
+		}
+
+		// Dependencies restarted just before have to meet their condition
+		// again.
+		for _, depName := range depNames {
+			if !restarted[depName] {
+				continue
+			}
+			if err := waitForDependency(ctx, cli, events, watcher, service.Name, depName, service.DependsOn[depName], wait); err != nil {
+				return err
+			}
+		}
+
+		c, err := serviceContainer(ctx, cli, stackConfig, service.Name)
+		if err != nil {
+			return err
+		}
+		if c == nil {
+			events.emitf(EventInfo, service.Name, "Service %s has no container to restart", service.Name)
+			continue
+		}
+		if err := restartContainer(ctx, cli, events, service.Name, *c, stopTimeout(service, opts.Timeout)); err != nil {
+			return err
+		}
+		restarted[service.Name] = true
+	}
+	return nil
+}
+
+// restartContainer stops the container if it's running and starts it again.
+func restartContainer(ctx context.Context, cli Engine, events *emitter, serviceName string, c container.Summary, timeout *int) error {
+	if c.State == container.StateRunning {
+		events.emit(Event{Type: EventContainerStopping, Service: serviceName, ContainerID: c.ID, Message: "Stopping container " + serviceName})
+		if err := cli.ContainerStop(ctx, c.ID, container.StopOptions{Timeout: timeout}); err != nil && !cerrdefs.IsNotFound(err) {
+			return fmt.Errorf("stop container %s: %w", serviceName, err)
+		}
+	}
+	if err := cli.ContainerStart(ctx, c.ID, container.StartOptions{}); err != nil {
+		return fmt.Errorf("start container %s (ID: %s): %w", serviceName, c.ID[:12], err)
+	}
+	events.emit(Event{
+		Type:        EventContainerRestarted,
+		Service:     serviceName,
+		ContainerID: c.ID,
+		Message:     fmt.Sprintf("Restarted container %s (ID: %s)", serviceName, c.ID[:12]),
+	})
+	return nil
+}
@@ -0,0 +1,200 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/compose-spec/compose-go/types"
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types/container"
+)
+
+// createdResources records what one Up call created, replaced or started so
+// it can be rolled back. A nil *createdResources records nothing.
+type createdResources struct {
+	mu         sync.Mutex
+	containers []createdContainer
+	// replaced holds the containers the call recreated. They are kept,
+	// stopped and renamed, until Up is done.
+	replaced []replacedContainer
+	// started holds the existing containers the call started.
+	started  []createdContainer
+	networks []string
+	volumes  []string
+}
+
+type createdContainer struct {
+	service string
+	id      string
+}
+
+type replacedContainer struct {
+	service string
+	id      string
+	// running is whether the container ran before it was replaced.
+	running bool
+}
+
+func (c *createdResources) addContainer(service, id string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.containers = append(c.containers, createdContainer{service: service, id: id})
+}
+
+func (c *createdResources) addReplaced(service, id string, running bool) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.replaced = append(c.replaced, replacedContainer{service: service, id: id, running: running})
+}
+
+func (c *createdResources) addStarted(service, id string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.started = append(c.started, createdContainer{service: service, id: id})
+}
+
+func (c *createdResources) addNetwork(name string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.networks = append(c.networks, name)
+}
+
+func (c *createdResources) addVolume(name string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.volumes = append(c.volumes, name)
+}
+
+// replacedName is the name a recreated container is kept under until Up is
+// done, like docker compose names it.
+func replacedName(service, id string) string {
+	return id[:12] + "_" + service
+}
+
+// replaceContainer stops the service's container and renames it out of the
+// way of the one replacing it, recording it so rollback can bring it back.
+func replaceContainer(ctx context.Context, cli Engine, events *emitter, created *createdResources, service types.ServiceConfig, c container.Summary) error {
+	running := c.State == container.StateRunning
+	if running {
+		events.emit(Event{Type: EventContainerStopping, Service: service.Name, ContainerID: c.ID, Message: "Stopping container " + service.Name})
+		if err := cli.ContainerStop(ctx, c.ID, container.StopOptions{Timeout: stopTimeout(service, nil)}); err != nil {
+			return fmt.Errorf("stop container %s: %w", service.Name, err)
+		}
+	}
+	name := replacedName(service.Name, c.ID)
+	if err := cli.ContainerRename(ctx, c.ID, name); err != nil {
+		return fmt.Errorf("rename container %s to %s: %w", service.Name, name, err)
+	}
+	created.addReplaced(service.Name, c.ID, running)
+	events.emitf(EventInfo, service.Name, "Kept container %s as %s until the project is up", service.Name, name)
+	return nil
+}
+
+// removeReplaced removes the containers kept by replaceContainer once Up
+// succeeded.
+func removeReplaced(ctx context.Context, cli Engine, events *emitter, created *createdResources) error {
+	created.mu.Lock()
+	defer created.mu.Unlock()
+	var errs []error
+	for _, c := range created.replaced {
+		summary := container.Summary{ID: c.id, State: container.StateExited}
+		if err := removeContainer(ctx, cli, events, c.service, summary, nil, false); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// rollback stops and removes the containers recorded in created, newest
+// first, brings back the ones they replaced and stops the ones that were
+// only started, then removes the networks and volumes. It keeps going after
+// a failure and returns all the errors it hit joined together.
+func rollback(ctx context.Context, cli Engine, events *emitter, stackConfig *types.Project, created *createdResources) error {
+	// The caller's context may be what failed the run, rolling back
+	// shouldn't be cut short by it.
+	ctx = context.WithoutCancel(ctx)
+
+	created.mu.Lock()
+	defer created.mu.Unlock()
+	if len(created.containers)+len(created.replaced)+len(created.started)+len(created.networks)+len(created.volumes) == 0 {
+		return nil
+	}
+	events.emitf(EventInfo, "", "Rolling back project %s", stackConfig.Name)
+
+	var errs []error
+	for i := len(created.containers) - 1; i >= 0; i-- {
+		c := created.containers[i]
+		service, _ := stackConfig.GetService(c.service)
+		// Stopping a container that isn't running is a no-op, so every
+		// container is treated as running.
+		summary := container.Summary{ID: c.id, State: container.StateRunning}
+		if err := removeContainer(ctx, cli, events, c.service, summary, stopTimeout(service, nil), true); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	// Replaced containers come back in the order they were replaced, which
+	// follows depends_on.
+	for _, c := range created.replaced {
+		if err := cli.ContainerRename(ctx, c.id, c.service); err != nil {
+			errs = append(errs, fmt.Errorf("restore container %s: %w", c.service, err))
+			continue
+		}
+		events.emitf(EventInfo, c.service, "Restored container %s", c.service)
+		if !c.running {
+			continue
+		}
+		if err := cli.ContainerStart(ctx, c.id, container.StartOptions{}); err != nil {
+			errs = append(errs, fmt.Errorf("start restored container %s: %w", c.service, err))
+			continue
+		}
+		events.emit(Event{Type: EventContainerStarted, Service: c.service, ContainerID: c.id, Message: "Started container " + c.service})
+	}
+	for i := len(created.started) - 1; i >= 0; i-- {
+		c := created.started[i]
+		service, _ := stackConfig.GetService(c.service)
+		events.emit(Event{Type: EventContainerStopping, Service: c.service, ContainerID: c.id, Message: "Stopping container " + c.service})
+		if err := cli.ContainerStop(ctx, c.id, container.StopOptions{Timeout: stopTimeout(service, nil)}); err != nil && !cerrdefs.IsNotFound(err) {
+			errs = append(errs, fmt.Errorf("stop container %s: %w", c.service, err))
+		}
+	}
+	for i := len(created.networks) - 1; i >= 0; i-- {
+		name := created.networks[i]
+		err := cli.NetworkRemove(ctx, name)
+		if err != nil && !cerrdefs.IsNotFound(err) {
+			errs = append(errs, fmt.Errorf("remove network %s: %w", name, err))
+		} else if err == nil {
+			events.emit(Event{Type: EventNetworkRemoved, Network: name, Message: "Removed network " + name})
+		}
+	}
+	for i := len(created.volumes) - 1; i >= 0; i-- {
+		name := created.volumes[i]
+		err := cli.VolumeRemove(ctx, name, true)
+		if err != nil && !cerrdefs.IsNotFound(err) {
+			errs = append(errs, fmt.Errorf("remove volume %s: %w", name, err))
+		} else if err == nil {
+			events.emit(Event{Type: EventVolumeRemoved, Volume: name, Message: "Removed volume " + name})
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("rollback: %w", err)
+	}
+	return nil
+}
@@ -3,18 +3,31 @@ package runner
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"io"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/JamesTiberiusKirk/go-docker-compose/internal/composeconvert"
+	"github.com/JamesTiberiusKirk/go-docker-compose/internal/registryauth"
 	"github.com/compose-spec/compose-go/types"
+	cerrdefs "github.com/containerd/errdefs"
 	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/api/types/image"
-	"github.com/docker/docker/client"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/registry"
+	"golang.org/x/sync/errgroup"
 )
 
-func waitForCondition(ctx context.Context, cli *client.Client, name, cond, targetHealth string) error {
+// waitForCondition blocks until the named container meets the depends_on
+// condition. The container is inspected once for its current state, after
+// that its events decide, with another inspect only when the event stream may
+// have missed something or the container died.
+//
+// It returns a *DependencyFailedError as soon as the container can no longer
+// meet the condition: it exited (with a non-zero code for
+// service_completed_successfully), was OOM-killed or became unhealthy.
+func waitForCondition(ctx context.Context, cli Engine, watcher *containerWatcher, name, cond, targetHealth string) error {
 	if cond == "" || cond == "service_started" {
 		return nil
 	}
@@ -22,23 +35,45 @@ func waitForCondition(ctx context.Context, cli *client.Client, name, cond, targe
 		targetHealth = "healthy"
 	}
 
+	// Subscribe before inspecting so nothing happening in between is lost.
+	sub, cancel := watcher.subscribe(name)
+	defer cancel()
+
+	failed := func(reason DependencyFailure, exitCode int) error {
+		return &DependencyFailedError{Dependency: name, Condition: cond, Reason: reason, ExitCode: exitCode}
+	}
+
+	var containerID string
 	check := func() (bool, error) {
 		info, err := cli.ContainerInspect(ctx, name)
 		if err != nil || info.State == nil {
 			return false, nil
 		}
+		containerID = info.ID
+		state := info.State
+		exited := state.Status == container.StateExited || state.Status == container.StateDead
 		switch cond {
 		case "service_healthy":
-			if info.State.Health != nil && info.State.Health.Status == targetHealth {
+			switch {
+			case state.OOMKilled && exited:
+				return false, failed(DependencyOOMKilled, state.ExitCode)
+			case exited:
+				return false, failed(DependencyExited, state.ExitCode)
+			case state.Health != nil && state.Health.Status == container.Unhealthy:
+				return false, failed(DependencyUnhealthy, 0)
+			case state.Health != nil && state.Health.Status == targetHealth:
 				return true, nil
 			}
 			return false, nil
 		case "service_completed_successfully":
-			if !info.State.Running {
-				if info.State.ExitCode == 0 {
-					return true, nil
+			if !state.Running {
+				if state.OOMKilled {
+					return false, failed(DependencyOOMKilled, state.ExitCode)
+				}
+				if state.ExitCode != 0 {
+					return false, failed(DependencyExited, state.ExitCode)
 				}
-				return false, fmt.Errorf("%s exited with code %d", name, info.State.ExitCode)
+				return true, nil
 			}
 			return false, nil
 		default:
@@ -46,10 +81,29 @@ func waitForCondition(ctx context.Context, cli *client.Client, name, cond, targe
 		}
 	}
 
-	ticker := time.NewTicker(200 * time.Millisecond)
-	defer ticker.Stop()
+	// fromEvent reports whether the event satisfies the condition, or whether
+	// the container has to be inspected to find out why it died.
+	fromEvent := func(msg events.Message) (done, recheck bool, err error) {
+		// Events of an earlier container with the same name are ignored.
+		if containerID != "" && msg.Actor.ID != containerID {
+			return false, false, nil
+		}
+		if msg.Action == events.ActionDie {
+			return false, true, nil
+		}
+		if cond == "service_healthy" {
+			switch msg.Action {
+			case events.Action(string(events.ActionHealthStatus) + ": " + targetHealth):
+				return true, false, nil
+			case events.Action(string(events.ActionHealthStatus) + ": " + string(container.Unhealthy)):
+				return false, false, failed(DependencyUnhealthy, 0)
+			}
+		}
+		return false, false, nil
+	}
 
 	for {
+		resync := watcher.resyncSignal()
 		done, err := check()
 		if err != nil {
 			return err
@@ -57,59 +111,488 @@ func waitForCondition(ctx context.Context, cli *client.Client, name, cond, targe
 		if done {
 			return nil
 		}
-		select {
-		case <-ctx.Done():
-			return fmt.Errorf("timeout waiting for %s (%s): %w", name, cond, ctx.Err())
-		case <-ticker.C:
+
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("timeout waiting for %s (%s): %w", name, cond, ctx.Err())
+			case msg := <-sub.events:
+				done, recheck, err := fromEvent(msg)
+				if err != nil {
+					return err
+				}
+				if done {
+					return nil
+				}
+				if recheck {
+					break wait
+				}
+			case <-sub.missed:
+				break wait
+			case <-resync:
+				break wait
+			}
+		}
+	}
+}
+
+type UpOptions struct {
+	// ForceRecreate recreates every container even if its config hasn't changed.
+	ForceRecreate bool
+	// NoRecreate never recreates existing containers, stopped ones are started.
+	NoRecreate bool
+	// PullPolicy overrides the pull_policy of every service when set. Besides
+	// the compose values it accepts daily, weekly and every_<duration>. With
+	// build, services without a build section fall back to missing.
+	PullPolicy string
+	// MaxParallelism caps how many services are pulled, built and started at
+	// the same time. Zero means no limit.
+	MaxParallelism int
+	// DockerConfigDir is the directory holding the config.json registry
+	// credentials are read from. Defaults to $DOCKER_CONFIG or ~/.docker.
+	DockerConfigDir string
+	// Events receives a typed event for every step. Defaults to a console
+	// sink on stdout.
+	Events EventSink
+	// WaitTimeout bounds how long a service waits for each of its depends_on
+	// conditions. Zero waits until ctx is done.
+	WaitTimeout time.Duration
+	// ServiceWaitTimeouts overrides WaitTimeout for the services it names,
+	// keyed by the service that waits, not by the one waited on. Each of the
+	// service's depends_on conditions gets the timeout.
+	ServiceWaitTimeouts map[string]time.Duration
+	// DiagnosticLogLines is how many of the last log lines of a dependency
+	// that failed or timed out are included in the error. Defaults to 20,
+	// negative leaves the logs out.
+	DiagnosticLogLines int
+	// Rollback makes a failed Up leave the project as it was found. The
+	// containers, networks and volumes it created are removed, containers it
+	// recreated are brought back and the ones it started are stopped again.
+	// A recreated container is kept, stopped and renamed, until Up is done.
+	// Pulled and built images are kept.
+	Rollback bool
+}
+
+// Run brings the project up with the default options, see Up.
+func Run(ctx context.Context, cli Engine, stackConfig *types.Project) error {
+	return Up(ctx, cli, stackConfig, UpOptions{})
+}
+
+// Up creates and starts the containers of the project. Every service is
+// scheduled as soon as all of its depends_on conditions are met, so
+// independent services come up concurrently. The first failure cancels the
+// work still in flight.
+//
+// Containers left over from a previous run are converged like docker compose
+// does: unchanged ones are left running (or started if stopped) and ones whose
+// config hash differs are recreated.
+//
+// Dependencies marked `required: false` that aren't in the project are
+// skipped. A running service is restarted when a dependency it marks
+// `restart: true` got (re)created or started by this call.
+//
+// With opts.Rollback a failure undoes whatever this call did to the
+// containers, networks and volumes, and the error returned joins the one
+// that failed Up with those of the rollback.
+func Up(ctx context.Context, cli Engine, stackConfig *types.Project, opts UpOptions) error {
+	if stackConfig.Name == "" {
+		return fmt.Errorf("project name must not be empty")
+	}
+	if opts.ForceRecreate && opts.NoRecreate {
+		return fmt.Errorf("ForceRecreate and NoRecreate are mutually exclusive")
+	}
+
+	events := newEmitter(opts.Events)
+	var created *createdResources
+	if opts.Rollback {
+		created = &createdResources{}
+	}
+	err := up(ctx, cli, events, stackConfig, opts, created)
+	if err != nil && created != nil {
+		if rollbackErr := rollback(ctx, cli, events, stackConfig, created); rollbackErr != nil {
+			events.emit(Event{Type: EventError, Err: rollbackErr})
+			err = errors.Join(err, rollbackErr)
+		}
+	} else if created != nil {
+		if err = removeReplaced(ctx, cli, events, created); err != nil {
+			events.emit(Event{Type: EventError, Err: err})
 		}
 	}
+	return err
 }
 
-func Run(ctx context.Context, cli *client.Client, stackConfig *types.Project) error {
+func up(ctx context.Context, cli Engine, events *emitter, stackConfig *types.Project, opts UpOptions, created *createdResources) error {
+	if err := ensureNetworks(ctx, cli, events, stackConfig, created); err != nil {
+		events.emit(Event{Type: EventError, Err: err})
+		return err
+	}
+	if err := ensureVolumes(ctx, cli, events, stackConfig, created); err != nil {
+		events.emit(Event{Type: EventError, Err: err})
+		return err
+	}
+
+	auth, err := registryauth.Load(opts.DockerConfigDir)
+	if err != nil {
+		events.emit(Event{Type: EventError, Err: err})
+		return err
+	}
+
+	watchCtx, stopWatching := context.WithCancel(ctx)
+	defer stopWatching()
+
+	run := &upRun{
+		cli:         cli,
+		watcher:     watchContainers(watchCtx, cli, stackConfig),
+		auth:        auth,
+		stackConfig: stackConfig,
+		opts:        opts,
+		events:      events,
+		created:     created,
+		started:     map[string]chan struct{}{},
+		changed:     map[string]bool{},
+		fresh:       map[string]bool{},
+	}
+	if opts.MaxParallelism > 0 {
+		run.slots = make(chan struct{}, opts.MaxParallelism)
+	}
+	for _, service := range stackConfig.Services {
+		run.started[service.Name] = make(chan struct{})
+	}
+
+	ordered, err := composeconvert.OrderServices(stackConfig.Services)
+	if err != nil {
+		err = fmt.Errorf("failed to order services: %w", err)
+		events.emit(Event{Type: EventError, Err: err})
+		return err
+	}
+	for _, service := range ordered {
+		events.emit(Event{Type: EventServiceQueued, Service: service.Name})
+	}
+
+	g, gctx := errgroup.WithContext(ctx)
 	for i := range stackConfig.Services {
-		service := stackConfig.Services[i]
-		fmt.Printf("\nPreparing service: %s\n", service.Name)
+		g.Go(func() error {
+			name := stackConfig.Services[i].Name
+			defer events.emit(Event{Type: EventServiceDone, Service: name})
+			if err := run.service(gctx, i); err != nil {
+				// Services cancelled because another one failed aren't
+				// reported, the failure that caused it is.
+				if !errors.Is(err, context.Canceled) || ctx.Err() != nil {
+					events.emit(Event{Type: EventError, Service: name, Err: err})
+				}
+				return err
+			}
+			close(run.started[name])
+			return nil
+		})
+	}
 
-		// wait for depends_on (keys already rewritten in composeconvert)
-		for depName, dep := range service.DependsOn {
-			if err := waitForCondition(ctx, cli, depName, string(dep.Condition), "healthy"); err != nil {
-				return fmt.Errorf("waiting on dependency %s for service %s: %w", depName, service.Name, err)
+	return g.Wait()
+}
+
+// upRun holds the state shared by the services of one Up call.
+type upRun struct {
+	cli         Engine
+	stackConfig *types.Project
+	opts        UpOptions
+	auth        *registryauth.Store
+	events      *emitter
+	watcher     *containerWatcher
+	// created is nil unless the run is rolled back on failure.
+	created *createdResources
+	// started has a channel per service that is closed once its container runs.
+	started map[string]chan struct{}
+
+	mu sync.Mutex
+	// changed holds the services whose container this run created, started
+	// or restarted.
+	changed map[string]bool
+	// fresh holds the services whose container this run created.
+	fresh map[string]bool
+	// slots limits the number of services worked on at once, nil when unlimited.
+	slots chan struct{}
+
+	// buildAuth caches the credentials of every registry, resolved on the
+	// first build of the run as they may take a credential helper call per
+	// registry.
+	buildAuthOnce sync.Once
+	buildAuth     map[string]registry.AuthConfig
+	buildAuthErr  error
+}
+
+// service brings up the i-th service of the project once its dependencies are
+// satisfied.
+func (r *upRun) service(ctx context.Context, i int) error {
+	cli := r.cli
+	events := r.events
+	stackConfig := r.stackConfig
+	opts := r.opts
+	service := stackConfig.Services[i]
+
+	// wait for depends_on (keys already rewritten in composeconvert)
+	depNames := make([]string, 0, len(service.DependsOn))
+	for depName := range service.DependsOn {
+		depNames = append(depNames, depName)
+	}
+	sort.Strings(depNames)
+	restartNeeded := false
+	for _, depName := range depNames {
+		dep := service.DependsOn[depName]
+		started, ok := r.started[depName]
+		if !ok {
+			if !composeconvert.OptionalDependency(dep) {
+				return fmt.Errorf("service %s depends on undefined service %s", service.Name, depName)
 			}
+			events.emitf(EventInfo, service.Name, "Service %s skipping optional dependency %s, it isn't in the project", service.Name, depName)
+			continue
 		}
+		select {
+		case <-started:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if err := waitForDependency(ctx, cli, events, r.watcher, service.Name, depName, dep, r.waitOptions(service.Name)); err != nil {
+			return err
+		}
+		if dep.Restart && r.wasChanged(depName) {
+			restartNeeded = true
+		}
+	}
 
-		if service.Build != nil {
-			if err := buildImage(ctx, cli, service); err != nil {
-				return fmt.Errorf("error building new image for service %s: %w", service.Name, err)
+	// A container joining the network, IPC or PID namespace of another one
+	// needs it to exist, depends_on or not. When that container was created
+	// by this run, the one joining it still points at its predecessor and
+	// has to be recreated.
+	namespaceReplaced := false
+	for _, depName := range composeconvert.NamespaceDependencies(service) {
+		started, ok := r.started[depName]
+		if !ok {
+			return fmt.Errorf("service %s shares a namespace with undefined service %s", service.Name, depName)
+		}
+		select {
+		case <-started:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if r.wasCreated(depName) {
+			namespaceReplaced = true
+		}
+	}
+
+	if r.slots != nil {
+		select {
+		case r.slots <- struct{}{}:
+			defer func() { <-r.slots }()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	events.emitf(EventServicePreparing, service.Name, "Preparing service: %s", service.Name)
+
+	if err := r.ensureImage(ctx, service); err != nil {
+		return err
+	}
+	// service is this worker's copy, the project is shared by every worker
+	// and left as the caller passed it.
+	if service.Image == "" && service.Build != nil {
+		service.Image = service.Name
+	}
+
+	config, hostConfig, netConfig, err := composeconvert.TranslateServiceConfigToContainerConfig(stackConfig, service)
+	if err != nil {
+		return fmt.Errorf("translate service %s config: %w", service.Name, err)
+	}
+
+	img, err := cli.ImageInspect(ctx, config.Image)
+	if err != nil {
+		return fmt.Errorf("inspect image %s: %w", config.Image, err)
+	}
+	hash, err := configHash(config, hostConfig, netConfig, img.ID)
+	if err != nil {
+		return fmt.Errorf("hash service %s config: %w", service.Name, err)
+	}
+	config.Labels = serviceLabels(stackConfig, service, hash)
+
+	existing, err := serviceContainer(ctx, cli, stackConfig, service.Name)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		if opts.NoRecreate || (!opts.ForceRecreate && !namespaceReplaced && existing.Labels[ConfigHashLabel] == hash) {
+			if existing.State == container.StateRunning && restartNeeded {
+				// A dependency with `restart: true` was (re)started.
+				r.markChanged(service.Name, false)
+				return restartContainer(ctx, cli, events, service.Name, *existing, stopTimeout(service, nil))
 			}
-			if service.Image == "" {
-				service.Image = service.Name
-				stackConfig.Services[i].Image = service.Image
+			if existing.State != container.StateRunning {
+				r.markChanged(service.Name, false)
 			}
-			fmt.Printf("Built image: %s\n", service.Image)
-		} else {
-			fmt.Printf("Pulling image: %s\n", service.Image)
-			reader, err := cli.ImagePull(ctx, service.Image, image.PullOptions{})
-			if err != nil {
-				return fmt.Errorf("pull image %s: %w", service.Image, err)
+			if err := startExisting(ctx, cli, events, service.Name, *existing); err != nil {
+				return err
 			}
-			io.Copy(io.Discard, reader)
-			reader.Close()
+			if existing.State != container.StateRunning {
+				r.created.addStarted(service.Name, existing.ID)
+			}
+			return nil
 		}
 
-		config, hostConfig, netConfig, err := composeconvert.TranslateServiceConfigToContainerConfig(service)
-		if err != nil {
-			return fmt.Errorf("translate service %s config: %w", service.Name, err)
+		events.emitf(EventContainerRecreating, service.Name, "Recreating container %s", service.Name)
+		if r.created != nil {
+			// Kept until Up is done, so a rollback can bring it back.
+			err = replaceContainer(ctx, cli, events, r.created, service, *existing)
+		} else {
+			err = removeContainer(ctx, cli, events, service.Name, *existing, stopTimeout(service, nil), false)
 		}
-
-		resp, err := cli.ContainerCreate(ctx, config, hostConfig, netConfig, nil, service.Name)
 		if err != nil {
-			return fmt.Errorf("create container %s: %w", service.Name, err)
+			return err
 		}
+	}
+
+	networkOrder := composeconvert.ServiceNetworkNames(stackConfig, service)
+	createNetConfig, otherNetworks := splitNetworkConfig(netConfig, string(hostConfig.NetworkMode))
+
+	resp, err := cli.ContainerCreate(ctx, config, hostConfig, createNetConfig, nil, service.Name)
+	if cerrdefs.IsConflict(err) {
+		// The project's own container was removed above, so the name is
+		// taken by one the runner didn't create.
+		return fmt.Errorf("create container %s: the name is taken by a container that isn't part of project %s, remove or rename it: %w", service.Name, stackConfig.Name, err)
+	}
+	if err != nil {
+		return fmt.Errorf("create container %s: %w", service.Name, err)
+	}
+	r.created.addContainer(service.Name, resp.ID)
+	events.emit(Event{
+		Type:        EventContainerCreated,
+		Service:     service.Name,
+		ContainerID: resp.ID,
+		Image:       config.Image,
+		Message:     fmt.Sprintf("Created container %s (ID: %s)", service.Name, resp.ID[:12]),
+	})
+
+	if err := connectNetworks(ctx, cli, resp.ID, networkOrder, otherNetworks); err != nil {
+		return fmt.Errorf("container %s: %w", service.Name, err)
+	}
+
+	if err := cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+		return fmt.Errorf("start container %s (ID: %s): %w", service.Name, resp.ID[:12], err)
+	}
+	events.emit(Event{
+		Type:        EventContainerStarted,
+		Service:     service.Name,
+		ContainerID: resp.ID,
+		Message:     fmt.Sprintf("Started container %s (ID: %s)", service.Name, resp.ID[:12]),
+	})
+	r.markChanged(service.Name, true)
+	return nil
+}
 
-		fmt.Printf("Starting container %s (ID: %s)\n", service.Name, resp.ID[:12])
-		if err := cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
-			return fmt.Errorf("start container %s (ID: %s): %w", service.Name, resp.ID[:12], err)
+// markChanged records that the service's container was created, started or
+// restarted by this run, which its `restart: true` dependents react to.
+func (r *upRun) markChanged(name string, created bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.changed[name] = true
+	if created {
+		r.fresh[name] = true
+	}
+}
+
+func (r *upRun) wasChanged(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.changed[name]
+}
+
+// wasCreated reports whether this run created the service's container.
+func (r *upRun) wasCreated(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.fresh[name]
+}
+
+// waitOptions bounds the wait on a dependency and says how much of its logs
+// a failure reports.
+type waitOptions struct {
+	// timeout is zero when only ctx bounds the wait.
+	timeout  time.Duration
+	logLines int
+}
+
+// waitOptions returns the wait options for the named service's dependencies.
+func (r *upRun) waitOptions(serviceName string) waitOptions {
+	wait := waitOptions{timeout: r.opts.WaitTimeout, logLines: r.opts.DiagnosticLogLines}
+	if timeout, ok := r.opts.ServiceWaitTimeouts[serviceName]; ok {
+		wait.timeout = timeout
+	}
+	if wait.logLines == 0 {
+		wait.logLines = defaultDiagnosticLogLines
+	}
+	return wait
+}
+
+// waitForDependency blocks until the dependency of the service meets its
+// depends_on condition. Unless the wait was cancelled, the error describes
+// the state, health checks and logs of the dependency.
+func waitForDependency(ctx context.Context, cli Engine, events *emitter, watcher *containerWatcher, serviceName, depName string, dep types.ServiceDependency, wait waitOptions) error {
+	events.emit(Event{
+		Type:       EventDependencyWaiting,
+		Service:    serviceName,
+		Dependency: depName,
+		Condition:  dep.Condition,
+		Message:    fmt.Sprintf("Service %s waiting for %s (%s)", serviceName, depName, dep.Condition),
+	})
+	waitCtx := ctx
+	if wait.timeout > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, wait.timeout)
+		defer cancel()
+	}
+	if err := waitForCondition(waitCtx, cli, watcher, depName, dep.Condition, "healthy"); err != nil {
+		var failed *DependencyFailedError
+		if errors.As(err, &failed) {
+			failed.Service = serviceName
+		}
+		if errors.Is(err, context.Canceled) {
+			return fmt.Errorf("waiting on dependency %s for service %s: %w", depName, serviceName, err)
+		}
+		if ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+			err = fmt.Errorf("gave up after %s: %w", wait.timeout, err)
 		}
+		return fmt.Errorf("waiting on dependency %s for service %s: %w%s", depName, serviceName, err, diagnose(ctx, cli, depName, wait.logLines))
+	}
+	events.emit(Event{
+		Type:       EventDependencySatisfied,
+		Service:    serviceName,
+		Dependency: depName,
+		Condition:  dep.Condition,
+		Message:    fmt.Sprintf("Service %s dependency %s is ready", serviceName, depName),
+	})
+	return nil
+}
+
+// startExisting starts a container kept from a previous run unless it's
+// already running.
+func startExisting(ctx context.Context, cli Engine, events *emitter, serviceName string, c container.Summary) error {
+	if c.State == container.StateRunning {
+		events.emit(Event{
+			Type:        EventContainerUpToDate,
+			Service:     serviceName,
+			ContainerID: c.ID,
+			Message:     fmt.Sprintf("Container %s is up to date", serviceName),
+		})
+		return nil
+	}
+
+	if err := cli.ContainerStart(ctx, c.ID, container.StartOptions{}); err != nil {
+		return fmt.Errorf("start container %s (ID: %s): %w", serviceName, c.ID[:12], err)
 	}
+	events.emit(Event{
+		Type:        EventContainerStarted,
+		Service:     serviceName,
+		ContainerID: c.ID,
+		Message:     fmt.Sprintf("Started existing container %s (ID: %s)", serviceName, c.ID[:12]),
+	})
 	return nil
 }
@@ -0,0 +1,1280 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JamesTiberiusKirk/go-docker-compose/internal/composeconvert"
+	"github.com/JamesTiberiusKirk/go-docker-compose/internal/fakeengine"
+	"github.com/compose-spec/compose-go/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var _ Engine = (*fakeengine.Engine)(nil)
+
+// discard keeps the runner's progress out of the test output.
+var discard = EventSinkFunc(func(Event) {})
+
+// loadProject loads a compose file written to a temp dir as project "unit".
+func loadProject(t *testing.T, composeYML string) *types.Project {
+	t.Helper()
+	project, err := tryLoadProject(t, composeYML, composeconvert.LoadComposeProjectOptions{})
+	require.NoError(t, err)
+	return project
+}
+
+// tryLoadProject writes the compose file to a temp dir and loads it with
+// opts, as project "unit" unless opts names another.
+func tryLoadProject(t *testing.T, composeYML string, opts composeconvert.LoadComposeProjectOptions) (*types.Project, error) {
+	t.Helper()
+	opts.DockerComposePath = filepath.Join(t.TempDir(), "docker-compose.yml")
+	require.NoError(t, os.WriteFile(opts.DockerComposePath, []byte(composeYML), 0644))
+	if opts.ProjectName == "" {
+		opts.ProjectName = "unit"
+	}
+	return composeconvert.LoadComposeStack(t.Context(), opts)
+}
+
+// indexOf returns the position of call in calls, or -1.
+func indexOf(calls []string, call string) int {
+	return slices.Index(calls, call)
+}
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func TestUp_FakeEngine(t *testing.T) {
+	tests := []struct {
+		name       string
+		composeYML string
+		behaviors  map[string]fakeengine.Behavior
+		setup      func(e *fakeengine.Engine)
+		expectErr  string
+		assertFunc func(t *testing.T, e *fakeengine.Engine)
+	}{
+		{
+			name: "Dependency_started_first",
+			composeYML: `
+services:
+  app:
+    image: alpine:latest
+    depends_on: [db]
+  db:
+    image: alpine:latest
+`,
+			assertFunc: func(t *testing.T, e *fakeengine.Engine) {
+				calls := e.Calls()
+				require.NotEqual(t, -1, indexOf(calls, "ContainerStart app"))
+				assert.Less(t, indexOf(calls, "ContainerStart db"), indexOf(calls, "ContainerCreate app"))
+			},
+		},
+		{
+			name: "Waits_for_healthy_dependency",
+			composeYML: `
+services:
+  app:
+    image: alpine:latest
+    depends_on:
+      db:
+        condition: service_healthy
+  db:
+    image: alpine:latest
+    healthcheck:
+      test: ["CMD", "true"]
+`,
+			behaviors: map[string]fakeengine.Behavior{
+				"db": {Health: []container.HealthStatus{container.Starting, container.Starting, container.Healthy}},
+			},
+			assertFunc: func(t *testing.T, e *fakeengine.Engine) {
+				calls := e.Calls()
+				assert.Less(t, indexOf(calls, "ContainerStart db"), indexOf(calls, "ContainerCreate app"))
+				app, ok := e.Container("app")
+				require.True(t, ok)
+				assert.True(t, app.Running)
+			},
+		},
+		{
+			name: "Completed_dependency_with_zero_exit_succeeds",
+			composeYML: `
+services:
+  app:
+    image: alpine:latest
+    depends_on:
+      migrate:
+        condition: service_completed_successfully
+  migrate:
+    image: alpine:latest
+`,
+			behaviors: map[string]fakeengine.Behavior{
+				"migrate": {Exit: true},
+			},
+			assertFunc: func(t *testing.T, e *fakeengine.Engine) {
+				app, ok := e.Container("app")
+				require.True(t, ok)
+				assert.True(t, app.Running)
+			},
+		},
+		{
+			name: "Start_error_stops_dependents",
+			composeYML: `
+services:
+  app:
+    image: alpine:latest
+    depends_on: [db]
+  db:
+    image: alpine:latest
+`,
+			behaviors: map[string]fakeengine.Behavior{
+				"db": {StartErr: errors.New("port is already allocated")},
+			},
+			expectErr: "port is already allocated",
+			assertFunc: func(t *testing.T, e *fakeengine.Engine) {
+				assert.Equal(t, -1, indexOf(e.Calls(), "ContainerCreate app"))
+			},
+		},
+		{
+			name: "Pull_error_is_returned",
+			composeYML: `
+services:
+  app:
+    image: private/app:latest
+    pull_policy: always
+`,
+			setup: func(e *fakeengine.Engine) {
+				e.SetPullError("private/app:latest", errors.New("pull access denied"))
+			},
+			expectErr: "pull access denied",
+		},
+		{
+			name: "Local_image_is_not_pulled",
+			composeYML: `
+services:
+  app:
+    image: local/app:dev
+`,
+			setup: func(e *fakeengine.Engine) {
+				e.AddImage("local/app:dev")
+			},
+			assertFunc: func(t *testing.T, e *fakeengine.Engine) {
+				assert.Equal(t, -1, indexOf(e.Calls(), "ImagePull local/app:dev"))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+
+			e := fakeengine.New()
+			for name, b := range tt.behaviors {
+				e.SetBehavior(name, b)
+			}
+			if tt.setup != nil {
+				tt.setup(e)
+			}
+
+			project := loadProject(t, tt.composeYML)
+			err := Up(ctx, e, project, UpOptions{Events: discard})
+			if tt.expectErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectErr)
+			} else {
+				require.NoError(t, err)
+			}
+			if tt.assertFunc != nil {
+				tt.assertFunc(t, e)
+			}
+		})
+	}
+}
+
+func TestUp_DependencyFailsFast(t *testing.T) {
+	healthyDB := `
+services:
+  app:
+    image: alpine:latest
+    depends_on:
+      db:
+        condition: service_healthy
+  db:
+    image: alpine:latest
+    healthcheck:
+      test: ["CMD", "false"]
+`
+	completedMigrate := `
+services:
+  app:
+    image: alpine:latest
+    depends_on:
+      db:
+        condition: service_completed_successfully
+  db:
+    image: alpine:latest
+`
+
+	tests := []struct {
+		name       string
+		composeYML string
+		behavior   fakeengine.Behavior
+		expectErr  string
+		reason     DependencyFailure
+		exitCode   int
+	}{
+		{
+			name:       "Unhealthy",
+			composeYML: healthyDB,
+			behavior:   fakeengine.Behavior{Health: []container.HealthStatus{container.Starting, container.Unhealthy}},
+			expectErr:  "db is unhealthy",
+			reason:     DependencyUnhealthy,
+		},
+		{
+			name:       "Exited_while_waiting_for_healthy",
+			composeYML: healthyDB,
+			behavior:   fakeengine.Behavior{Exit: true, ExitCode: 0},
+			expectErr:  "db exited with code 0",
+			reason:     DependencyExited,
+		},
+		{
+			name:       "OOM_killed_while_waiting_for_healthy",
+			composeYML: healthyDB,
+			behavior:   fakeengine.Behavior{Exit: true, ExitCode: 137, OOMKilled: true},
+			expectErr:  "db was OOM-killed (exit code 137)",
+			reason:     DependencyOOMKilled,
+			exitCode:   137,
+		},
+		{
+			name:       "Completed_with_non_zero_exit",
+			composeYML: completedMigrate,
+			behavior:   fakeengine.Behavior{Exit: true, ExitCode: 3},
+			expectErr:  "db exited with code 3",
+			reason:     DependencyExited,
+			exitCode:   3,
+		},
+		{
+			name:       "OOM_killed_while_waiting_for_completion",
+			composeYML: completedMigrate,
+			behavior:   fakeengine.Behavior{Exit: true, ExitCode: 137, OOMKilled: true},
+			expectErr:  "db was OOM-killed",
+			reason:     DependencyOOMKilled,
+			exitCode:   137,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			// The deadline is far off, a failed dependency must end the wait
+			// well before it.
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			e := fakeengine.New()
+			e.SetBehavior("db", tt.behavior)
+			project := loadProject(t, tt.composeYML)
+
+			start := time.Now()
+			err := Up(ctx, e, project, UpOptions{Events: discard, WaitTimeout: 5 * time.Second})
+			require.Error(t, err)
+			assert.Less(t, time.Since(start), time.Second)
+			assert.Contains(t, err.Error(), tt.expectErr)
+			assert.NotErrorIs(t, err, context.DeadlineExceeded)
+
+			var failed *DependencyFailedError
+			require.ErrorAs(t, err, &failed)
+			assert.Equal(t, "app", failed.Service)
+			assert.Equal(t, "db", failed.Dependency)
+			assert.Equal(t, tt.reason, failed.Reason)
+			assert.Equal(t, tt.exitCode, failed.ExitCode)
+			assert.Equal(t, -1, indexOf(e.Calls(), "ContainerCreate app"))
+		})
+	}
+
+	t.Run("Timeout_is_not_a_dependency_failure", func(t *testing.T) {
+		t.Parallel()
+		e := fakeengine.New()
+		e.SetBehavior("db", fakeengine.Behavior{Health: []container.HealthStatus{container.Starting}})
+		project := loadProject(t, healthyDB)
+
+		err := Up(t.Context(), e, project, UpOptions{Events: discard, WaitTimeout: 50 * time.Millisecond})
+		require.Error(t, err)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		var failed *DependencyFailedError
+		assert.False(t, errors.As(err, &failed))
+	})
+}
+
+func TestUp_WaitTimeoutDiagnostics(t *testing.T) {
+	healthyDB := `
+services:
+  app:
+    image: alpine:latest
+    depends_on:
+      db:
+        condition: service_healthy
+  db:
+    image: alpine:latest
+    healthcheck:
+      test: ["CMD", "pg_isready"]
+`
+	stuck := fakeengine.Behavior{
+		Health:       []container.HealthStatus{container.Starting, container.Starting},
+		HealthOutput: "connection refused",
+		Logs:         "booting\nstill booting\nwaiting for disk\n",
+	}
+
+	tests := []struct {
+		name       string
+		composeYML string
+		behavior   fakeengine.Behavior
+		opts       UpOptions
+		expect     []string
+		notExpect  []string
+	}{
+		{
+			name:       "Global_timeout",
+			composeYML: healthyDB,
+			behavior:   stuck,
+			opts:       UpOptions{WaitTimeout: 100 * time.Millisecond, DiagnosticLogLines: 2},
+			expect: []string{
+				"waiting on dependency db for service app: gave up after 100ms",
+				"container db: running, health starting",
+				"exit 1: connection refused",
+				"last 2 log lines:\n  still booting\n  waiting for disk",
+			},
+			notExpect: []string{"  booting"},
+		},
+		{
+			name:       "Service_timeout_overrides_global",
+			composeYML: healthyDB,
+			behavior:   stuck,
+			opts: UpOptions{
+				WaitTimeout:         time.Minute,
+				ServiceWaitTimeouts: map[string]time.Duration{"app": 100 * time.Millisecond},
+			},
+			expect: []string{"gave up after 100ms", "last 3 log lines:"},
+		},
+		{
+			name:       "Timeout_of_the_dependency_isnt_used",
+			composeYML: healthyDB,
+			behavior:   stuck,
+			opts: UpOptions{
+				WaitTimeout:         100 * time.Millisecond,
+				ServiceWaitTimeouts: map[string]time.Duration{"db": time.Minute},
+			},
+			expect: []string{"gave up after 100ms"},
+		},
+		{
+			name:       "Logs_left_out",
+			composeYML: healthyDB,
+			behavior:   stuck,
+			opts:       UpOptions{WaitTimeout: 100 * time.Millisecond, DiagnosticLogLines: -1},
+			expect:     []string{"health starting"},
+			notExpect:  []string{"log lines"},
+		},
+		{
+			name: "Exit_code_and_logs_of_failed_dependency",
+			composeYML: `
+services:
+  app:
+    image: alpine:latest
+    depends_on:
+      db:
+        condition: service_completed_successfully
+  db:
+    image: alpine:latest
+`,
+			behavior: fakeengine.Behavior{Exit: true, ExitCode: 3, Logs: "migration 42 failed\n"},
+			expect: []string{
+				"db exited with code 3",
+				"container db: exited, exit code 3",
+				"last 1 log lines:\n  migration 42 failed",
+			},
+			notExpect: []string{"gave up"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+
+			e := fakeengine.New()
+			e.SetBehavior("db", tt.behavior)
+			project := loadProject(t, tt.composeYML)
+			opts := tt.opts
+			opts.Events = discard
+
+			start := time.Now()
+			err := Up(ctx, e, project, opts)
+			require.Error(t, err)
+			assert.Less(t, time.Since(start), time.Second, "the wait timeout, not the context, ends the wait")
+			for _, want := range tt.expect {
+				assert.Contains(t, err.Error(), want)
+			}
+			for _, unwanted := range tt.notExpect {
+				assert.NotContains(t, err.Error(), unwanted)
+			}
+		})
+	}
+}
+
+func TestUp_FakeEngineConverge(t *testing.T) {
+	composeYML := `
+services:
+  web:
+    image: alpine:latest
+    environment:
+      MODE: one
+`
+	e := fakeengine.New()
+	project := loadProject(t, composeYML)
+	require.NoError(t, Up(t.Context(), e, project, UpOptions{Events: discard}))
+	first, ok := e.Container("web")
+	require.True(t, ok)
+
+	// Unchanged config keeps the container.
+	require.NoError(t, Up(t.Context(), e, project, UpOptions{Events: discard}))
+	same, ok := e.Container("web")
+	require.True(t, ok)
+	assert.Equal(t, first.ID, same.ID)
+
+	// Changed config recreates it.
+	project.Services[0].Environment["MODE"] = strPtr("two")
+	require.NoError(t, Up(t.Context(), e, project, UpOptions{Events: discard}))
+	recreated, ok := e.Container("web")
+	require.True(t, ok)
+	assert.NotEqual(t, first.ID, recreated.ID)
+	assert.Contains(t, recreated.Config.Env, "MODE=two")
+
+	// A newer image under the same tag recreates it too.
+	e.UpdateImage("alpine:latest")
+	require.NoError(t, Up(t.Context(), e, project, UpOptions{Events: discard}))
+	updated, ok := e.Container("web")
+	require.True(t, ok)
+	assert.NotEqual(t, recreated.ID, updated.ID)
+}
+
+func TestUp_NameTakenByForeignContainer(t *testing.T) {
+	project := loadProject(t, `
+services:
+  web:
+    image: alpine:latest
+`)
+	e := fakeengine.New()
+	e.AddImage("alpine:latest")
+	_, err := e.ContainerCreate(t.Context(), &container.Config{Image: "alpine:latest"}, &container.HostConfig{}, nil, nil, "web")
+	require.NoError(t, err)
+
+	err = Up(t.Context(), e, project, UpOptions{Events: discard})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "isn't part of project unit")
+}
+
+func TestUp_GlobalBuildPullPolicy(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM alpine\n"), 0644))
+	project, err := tryLoadProject(t, `
+services:
+  app:
+    build: .
+  db:
+    image: postgres:16
+  cache:
+    image: redis:7
+`, composeconvert.LoadComposeProjectOptions{WorkingDir: dir})
+	require.NoError(t, err)
+
+	e := fakeengine.New()
+	e.AddImage("redis:7")
+	require.NoError(t, Up(t.Context(), e, project, UpOptions{Events: discard, PullPolicy: types.PullPolicyBuild}))
+
+	// Only the service with a build section is built, the others get their
+	// image as with missing.
+	_, built := e.LastBuild("app")
+	assert.True(t, built)
+	assert.NotEqual(t, -1, indexOf(e.Calls(), "ImagePull postgres:16"))
+	assert.Equal(t, -1, indexOf(e.Calls(), "ImagePull redis:7"))
+}
+
+func TestUp_BuildAuthResolvedOncePerUp(t *testing.T) {
+	// A credential store that logs every call it gets.
+	binDir := t.TempDir()
+	calls := filepath.Join(binDir, "calls")
+	helper := `#!/bin/sh
+echo "$1" >> ` + calls + `
+case "$1" in
+  list) echo '{"private.example.com":"storeuser"}' ;;
+  get) echo '{"ServerURL":"private.example.com","Username":"storeuser","Secret":"storepass"}' ;;
+esac
+`
+	require.NoError(t, os.WriteFile(filepath.Join(binDir, "docker-credential-fakestore"), []byte(helper), 0755))
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	configDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(configDir, "config.json"), []byte(`{"credsStore":"fakestore"}`), 0600))
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM private.example.com/base\n"), 0644))
+	project, err := tryLoadProject(t, `
+services:
+  api:
+    build: .
+  worker:
+    build: .
+  jobs:
+    build: .
+`, composeconvert.LoadComposeProjectOptions{WorkingDir: dir})
+	require.NoError(t, err)
+
+	e := fakeengine.New()
+	require.NoError(t, Up(t.Context(), e, project, UpOptions{Events: discard, DockerConfigDir: configDir}))
+
+	for _, service := range []string{"api", "worker", "jobs"} {
+		b, ok := e.LastBuild(service)
+		require.True(t, ok, service)
+		assert.Equal(t, "storeuser", b.Options.AuthConfigs["private.example.com"].Username, service)
+	}
+	logged, err := os.ReadFile(calls)
+	require.NoError(t, err)
+	assert.Equal(t, "list\nget\n", string(logged))
+}
+
+func TestUp_OptionalDependencies(t *testing.T) {
+	tests := []struct {
+		name       string
+		composeYML string
+		expectErr  string
+	}{
+		{
+			name: "Undefined_optional_dependency_is_skipped",
+			composeYML: `
+services:
+  app:
+    image: alpine:latest
+    depends_on:
+      cache:
+        condition: service_healthy
+        required: false
+`,
+		},
+		{
+			name: "Optional_dependency_disabled_by_profile_is_skipped",
+			composeYML: `
+services:
+  app:
+    image: alpine:latest
+    depends_on:
+      cache:
+        condition: service_healthy
+        required: false
+  cache:
+    image: alpine:latest
+    profiles: [cache]
+`,
+		},
+		{
+			name: "Required_dependency_disabled_by_profile_fails",
+			composeYML: `
+services:
+  app:
+    image: alpine:latest
+    depends_on: [cache]
+  cache:
+    image: alpine:latest
+    profiles: [cache]
+`,
+			expectErr: "service app depends on undefined service cache",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			project, err := tryLoadProject(t, tt.composeYML, composeconvert.LoadComposeProjectOptions{})
+			if tt.expectErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectErr)
+				return
+			}
+			require.NoError(t, err)
+
+			e := fakeengine.New()
+			require.NoError(t, Up(t.Context(), e, project, UpOptions{Events: discard}))
+			assert.NotEqual(t, -1, indexOf(e.Calls(), "ContainerStart app"))
+		})
+	}
+
+	t.Run("Dependency_removed_from_config_is_skipped_only_if_optional_in_the_file", func(t *testing.T) {
+		t.Parallel()
+		project := loadProject(t, `
+services:
+  app:
+    image: alpine:latest
+    depends_on:
+      db:
+        condition: service_started
+      cache:
+        condition: service_started
+        required: false
+  db:
+    image: alpine:latest
+  cache:
+    image: alpine:latest
+`)
+		project.Services = slices.DeleteFunc(project.Services, func(s types.ServiceConfig) bool { return s.Name == "cache" })
+		require.NoError(t, Up(t.Context(), fakeengine.New(), project, UpOptions{Events: discard}))
+
+		project.Services = slices.DeleteFunc(project.Services, func(s types.ServiceConfig) bool { return s.Name == "db" })
+		err := Up(t.Context(), fakeengine.New(), project, UpOptions{Events: discard})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "service app depends on undefined service db")
+
+		// Required false alone, as in a dependency built by hand, doesn't
+		// make it optional.
+		project.Services[0].DependsOn["db"] = types.ServiceDependency{Condition: types.ServiceConditionStarted}
+		err = Up(t.Context(), fakeengine.New(), project, UpOptions{Events: discard})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "service app depends on undefined service db")
+	})
+}
+
+// restartDependentsYML has app restart with db while worker doesn't.
+const restartDependentsYML = `
+services:
+  app:
+    image: alpine:latest
+    depends_on:
+      db:
+        condition: service_healthy
+        restart: true
+  worker:
+    image: alpine:latest
+    depends_on: [db]
+  db:
+    image: alpine:latest
+    environment:
+      MODE: one
+    healthcheck:
+      test: ["CMD", "true"]
+`
+
+func TestUp_RestartDependents(t *testing.T) {
+	e := fakeengine.New()
+	project := loadProject(t, restartDependentsYML)
+	require.NoError(t, Up(t.Context(), e, project, UpOptions{Events: discard}))
+	app, ok := e.Container("app")
+	require.True(t, ok)
+
+	// Nothing changed, nothing restarts.
+	require.NoError(t, Up(t.Context(), e, project, UpOptions{Events: discard}))
+	assert.Equal(t, -1, indexOf(e.Calls(), "ContainerStop app"))
+
+	// Recreating db restarts app in place but leaves worker alone.
+	for i := range project.Services {
+		if project.Services[i].Name == "db" {
+			project.Services[i].Environment["MODE"] = strPtr("two")
+		}
+	}
+	var restarted []string
+	sink := EventSinkFunc(func(e Event) {
+		if e.Type == EventContainerRestarted {
+			restarted = append(restarted, e.Service)
+		}
+	})
+	require.NoError(t, Up(t.Context(), e, project, UpOptions{Events: sink}))
+
+	calls := e.Calls()
+	assert.Equal(t, []string{"app"}, restarted)
+	assert.Less(t, indexOf(calls, "ContainerCreate db"), indexOf(calls, "ContainerStop app"))
+	assert.Equal(t, -1, indexOf(calls, "ContainerStop worker"))
+	same, ok := e.Container("app")
+	require.True(t, ok)
+	assert.Equal(t, app.ID, same.ID)
+	assert.True(t, same.Running)
+}
+
+func TestRestart_FakeEngine(t *testing.T) {
+	tests := []struct {
+		name      string
+		services  []string
+		restarted []string
+		expectErr string
+	}{
+		{
+			name:      "Dependents_with_restart_follow",
+			services:  []string{"db"},
+			restarted: []string{"db", "app"},
+		},
+		{
+			name:      "Dependent_alone",
+			services:  []string{"worker"},
+			restarted: []string{"worker"},
+		},
+		{
+			name:      "All_services",
+			restarted: []string{"db", "app", "worker"},
+		},
+		{
+			name:      "Unknown_service",
+			services:  []string{"nope"},
+			expectErr: "no service nope in project unit",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+
+			e := fakeengine.New()
+			project := loadProject(t, restartDependentsYML)
+			require.NoError(t, Up(ctx, e, project, UpOptions{Events: discard}))
+
+			var restarted []string
+			var waited bool
+			sink := EventSinkFunc(func(e Event) {
+				switch e.Type {
+				case EventContainerRestarted:
+					restarted = append(restarted, e.Service)
+				case EventDependencySatisfied:
+					waited = waited || (e.Service == "app" && e.Dependency == "db")
+				}
+			})
+			err := Restart(ctx, e, project, tt.services, RestartOptions{Events: sink})
+			if tt.expectErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.restarted, restarted)
+			if slices.Contains(tt.restarted, "db") {
+				assert.True(t, waited, "app waits for db to be healthy again")
+			}
+		})
+	}
+}
+
+// failingRemoveEngine fails to remove the named container.
+type failingRemoveEngine struct {
+	*fakeengine.Engine
+	name string
+}
+
+func (e failingRemoveEngine) ContainerRemove(ctx context.Context, containerID string, options container.RemoveOptions) error {
+	if c, ok := e.Container(containerID); ok && c.Name == e.name {
+		return errors.New("device or resource busy")
+	}
+	return e.Engine.ContainerRemove(ctx, containerID, options)
+}
+
+func TestUp_Rollback(t *testing.T) {
+	composeYML := `
+services:
+  keep:
+    image: alpine:latest
+  db:
+    image: alpine:latest
+    networks: [backend]
+    volumes:
+      - data:/data
+  app:
+    image: alpine:latest
+    depends_on: [db]
+    networks: [backend]
+networks:
+  backend:
+volumes:
+  data:
+`
+
+	t.Run("Removes_what_the_call_created", func(t *testing.T) {
+		t.Parallel()
+		e := fakeengine.New()
+		project := loadProject(t, composeYML)
+
+		// keep exists before the failing run and must survive it.
+		keepOnly := *project
+		keepOnly.Services = slices.DeleteFunc(slices.Clone(project.Services), func(s types.ServiceConfig) bool {
+			return s.Name != "keep"
+		})
+		require.NoError(t, Up(t.Context(), e, &keepOnly, UpOptions{Events: discard}))
+		keep, ok := e.Container("keep")
+		require.True(t, ok)
+
+		e.SetBehavior("app", fakeengine.Behavior{StartErr: errors.New("port is already allocated")})
+		err := Up(t.Context(), e, project, UpOptions{Events: discard, Rollback: true})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "port is already allocated")
+		assert.NotContains(t, err.Error(), "rollback")
+
+		for _, name := range []string{"db", "app"} {
+			_, ok := e.Container(name)
+			assert.False(t, ok, "%s is rolled back", name)
+		}
+		calls := e.Calls()
+		assert.Less(t, indexOf(calls, "ContainerRemove app"), indexOf(calls, "ContainerRemove db"), "newest first")
+		assert.NotEqual(t, -1, indexOf(calls, "NetworkRemove unit_backend"))
+		assert.NotEqual(t, -1, indexOf(calls, "VolumeRemove unit_data"))
+
+		kept, ok := e.Container("keep")
+		require.True(t, ok)
+		assert.Equal(t, keep.ID, kept.ID)
+		assert.True(t, kept.Running)
+	})
+
+	t.Run("Restores_recreated_containers", func(t *testing.T) {
+		t.Parallel()
+		e := fakeengine.New()
+		project := loadProject(t, composeYML)
+		withoutApp := *project
+		withoutApp.Services = slices.DeleteFunc(slices.Clone(project.Services), func(s types.ServiceConfig) bool {
+			return s.Name == "app"
+		})
+		require.NoError(t, Up(t.Context(), e, &withoutApp, UpOptions{Events: discard}))
+		require.NoError(t, e.ContainerStop(t.Context(), "keep", container.StopOptions{}))
+		before := map[string]fakeengine.Container{}
+		for _, name := range []string{"keep", "db"} {
+			c, ok := e.Container(name)
+			require.True(t, ok)
+			before[name] = c
+		}
+
+		// keep and db change and are recreated, then the new app fails to
+		// start.
+		changed := loadProject(t, strings.ReplaceAll(composeYML, "    image: alpine:latest\n", "    image: alpine:latest\n    environment: [VERSION=2]\n"))
+		e.SetBehavior("app", fakeengine.Behavior{StartErr: errors.New("port is already allocated")})
+		err := Up(t.Context(), e, changed, UpOptions{Events: discard, Rollback: true})
+		require.Error(t, err)
+		assert.NotContains(t, err.Error(), "rollback")
+		assert.NotEqual(t, -1, indexOf(e.Calls(), "ContainerRename db"), "db was recreated")
+
+		for name, old := range before {
+			c, ok := e.Container(name)
+			require.True(t, ok, name)
+			assert.Equal(t, old.ID, c.ID, "%s is the container from before", name)
+			assert.Equal(t, old.Running, c.Running, "%s is back in the state it was in", name)
+		}
+		list, err := Ps(t.Context(), e, changed)
+		require.NoError(t, err)
+		assert.Len(t, list, 2, "only the containers from before are left")
+	})
+
+	t.Run("Removes_replaced_containers_once_up", func(t *testing.T) {
+		t.Parallel()
+		e := fakeengine.New()
+		require.NoError(t, Up(t.Context(), e, loadProject(t, composeYML), UpOptions{Events: discard}))
+		oldDB, ok := e.Container("db")
+		require.True(t, ok)
+
+		changed := loadProject(t, strings.ReplaceAll(composeYML, "    volumes:\n", "    environment: [VERSION=2]\n    volumes:\n"))
+		require.NoError(t, Up(t.Context(), e, changed, UpOptions{Events: discard, Rollback: true}))
+
+		db, ok := e.Container("db")
+		require.True(t, ok)
+		assert.NotEqual(t, oldDB.ID, db.ID)
+		_, ok = e.Container(oldDB.ID)
+		assert.False(t, ok, "the replaced container is removed")
+		list, err := Ps(t.Context(), e, changed)
+		require.NoError(t, err)
+		assert.Len(t, list, 3)
+	})
+
+	t.Run("Stops_containers_it_started", func(t *testing.T) {
+		t.Parallel()
+		e := fakeengine.New()
+		project := loadProject(t, composeYML)
+		keepOnly := *project
+		keepOnly.Services = slices.DeleteFunc(slices.Clone(project.Services), func(s types.ServiceConfig) bool {
+			return s.Name != "keep"
+		})
+		require.NoError(t, Up(t.Context(), e, &keepOnly, UpOptions{Events: discard}))
+		require.NoError(t, e.ContainerStop(t.Context(), "keep", container.StopOptions{}))
+
+		e.SetBehavior("app", fakeengine.Behavior{StartErr: errors.New("port is already allocated")})
+		require.Error(t, Up(t.Context(), e, project, UpOptions{Events: discard, Rollback: true}))
+
+		keep, ok := e.Container("keep")
+		require.True(t, ok)
+		assert.True(t, keep.Started, "started by the failed run")
+		assert.False(t, keep.Running, "stopped again by the rollback")
+	})
+
+	t.Run("Off_by_default", func(t *testing.T) {
+		t.Parallel()
+		e := fakeengine.New()
+		e.SetBehavior("app", fakeengine.Behavior{StartErr: errors.New("port is already allocated")})
+		project := loadProject(t, composeYML)
+
+		require.Error(t, Up(t.Context(), e, project, UpOptions{Events: discard}))
+		db, ok := e.Container("db")
+		require.True(t, ok)
+		assert.True(t, db.Running)
+		_, ok = e.Container("app")
+		assert.True(t, ok, "the created container is left behind")
+	})
+
+	t.Run("Reports_rollback_errors", func(t *testing.T) {
+		t.Parallel()
+		fake := fakeengine.New()
+		fake.SetBehavior("app", fakeengine.Behavior{StartErr: errors.New("port is already allocated")})
+		e := failingRemoveEngine{Engine: fake, name: "db"}
+		project := loadProject(t, composeYML)
+
+		err := Up(t.Context(), e, project, UpOptions{Events: discard, Rollback: true})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "port is already allocated")
+		assert.Contains(t, err.Error(), "rollback: remove container db: device or resource busy")
+
+		_, ok := fake.Container("app")
+		assert.False(t, ok, "rollback keeps going after a failure")
+	})
+}
+
+func TestUp_NetworkMode(t *testing.T) {
+	composeYML := `
+services:
+  app:
+    image: alpine:latest
+    network_mode: service:vpn
+  vpn:
+    image: alpine:latest
+    environment:
+      MODE: one
+  tool:
+    image: alpine:latest
+    network_mode: host
+`
+	project, err := tryLoadProject(t, composeYML, composeconvert.LoadComposeProjectOptions{NamePrefix: "p-"})
+	require.NoError(t, err)
+	// The sort and the runner don't rely on the depends_on the loader adds.
+	for i := range project.Services {
+		project.Services[i].DependsOn = nil
+	}
+
+	e := fakeengine.New()
+	require.NoError(t, Up(t.Context(), e, project, UpOptions{Events: discard}))
+
+	app, ok := e.Container("p-app")
+	require.True(t, ok)
+	assert.Equal(t, container.NetworkMode("container:p-vpn"), app.HostConfig.NetworkMode)
+	assert.Empty(t, app.Config.Hostname)
+	assert.Empty(t, app.Networks)
+	calls := e.Calls()
+	assert.Less(t, indexOf(calls, "ContainerStart p-vpn"), indexOf(calls, "ContainerCreate p-app"))
+
+	tool, ok := e.Container("p-tool")
+	require.True(t, ok)
+	assert.Equal(t, container.NetworkMode("host"), tool.HostConfig.NetworkMode)
+
+	// Recreating vpn leaves app pointing at a removed container, so it's
+	// recreated as well.
+	for i := range project.Services {
+		if project.Services[i].Name == "p-vpn" {
+			project.Services[i].Environment["MODE"] = strPtr("two")
+		}
+	}
+	require.NoError(t, Up(t.Context(), e, project, UpOptions{Events: discard}))
+	recreated, ok := e.Container("p-app")
+	require.True(t, ok)
+	assert.NotEqual(t, app.ID, recreated.ID)
+	sameTool, ok := e.Container("p-tool")
+	require.True(t, ok)
+	assert.Equal(t, tool.ID, sameTool.ID)
+}
+
+func TestUp_NetworkModeConflicts(t *testing.T) {
+	project := loadProject(t, `
+services:
+  app:
+    image: alpine:latest
+    network_mode: service:vpn
+    ports:
+      - "8080:80"
+  vpn:
+    image: alpine:latest
+`)
+	err := Up(t.Context(), fakeengine.New(), project, UpOptions{Events: discard})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "service app: ports can't be published with network_mode service:vpn")
+}
+
+func TestUp_BuildDockerfileOutsideContext(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"app/main.txt":          "hello",
+		"app/debug.log":         "noise",
+		"app/.dockerignore":     "*.log\n",
+		"docker/app.Dockerfile": "FROM alpine\nCOPY main.txt /\n",
+	} {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+	project, err := tryLoadProject(t, `
+services:
+  external:
+    build:
+      context: ./app
+      dockerfile: ../docker/app.Dockerfile
+  inline:
+    build:
+      context: ./app
+      dockerfile_inline: |
+        FROM busybox
+`, composeconvert.LoadComposeProjectOptions{WorkingDir: dir})
+	require.NoError(t, err)
+
+	e := fakeengine.New()
+	require.NoError(t, Up(t.Context(), e, project, UpOptions{Events: discard}))
+
+	for service, dockerfile := range map[string]string{
+		"external": "FROM alpine\nCOPY main.txt /\n",
+		"inline":   "FROM busybox\n",
+	} {
+		b, ok := e.LastBuild(service)
+		require.True(t, ok, service)
+		// The Dockerfile is sent along with the context, which is left as it
+		// is on disk.
+		assert.True(t, strings.HasPrefix(b.Options.Dockerfile, ".dockerfile."), service)
+		assert.Equal(t, dockerfile, b.Files[b.Options.Dockerfile], service)
+		assert.Equal(t, "hello", b.Files["main.txt"], service)
+		assert.NotContains(t, b.Files, "debug.log", service)
+		assert.Contains(t, b.Files[".dockerignore"], "*.log\n", service)
+		assert.Contains(t, b.Files[".dockerignore"], "\n"+b.Options.Dockerfile+"\n", service)
+	}
+	assert.NoFileExists(t, filepath.Join(dir, "app", "Dockerfile"))
+	// The image name of build-only services isn't written back to the
+	// project, which the services' workers share.
+	for _, service := range project.Services {
+		assert.Empty(t, service.Image, service.Name)
+	}
+}
+
+func TestUp_BuildAdditionalContexts(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"app/Dockerfile":       "# escape=\\\nARG VERSION=1\nFROM alpine \\\n  AS build\nCOPY --from=assets / /assets\n",
+		"assets/logo.svg":      "<svg/>",
+		"assets/draft.tmp":     "scratch",
+		"assets/.dockerignore": "*.tmp\n",
+	} {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+
+	tests := []struct {
+		name       string
+		contexts   string
+		expectErr  string
+		assertFunc func(t *testing.T, e *fakeengine.Engine)
+	}{
+		{
+			name: "Directory_and_image_contexts",
+			contexts: `
+        assets: ./assets
+        base: docker-image://busybox:1.36`,
+			assertFunc: func(t *testing.T, e *fakeengine.Engine) {
+				var contextImage string
+				for _, call := range e.Calls() {
+					if ref, ok := strings.CutPrefix(call, "ImageBuild "+contextImageRepo+":"); ok {
+						contextImage = contextImageRepo + ":" + ref
+					}
+				}
+				require.NotEmpty(t, contextImage, "the assets directory is built first")
+				assert.NotEqual(t, -1, indexOf(e.Calls(), "ImageRemove "+contextImage), "and removed after")
+
+				b, ok := e.LastBuild(contextImage)
+				require.True(t, ok)
+				assert.Equal(t, contextDockerfile, b.Files[b.Options.Dockerfile])
+				assert.Equal(t, "<svg/>", b.Files["logo.svg"])
+				assert.NotContains(t, b.Files, "draft.tmp")
+
+				b, ok = e.LastBuild("app")
+				require.True(t, ok)
+				assert.Equal(t, "# escape=\\\nARG VERSION=1\n"+
+					"FROM "+contextImage+" AS assets\n"+
+					"FROM busybox:1.36 AS base\n"+
+					"FROM alpine \\\n  AS build\nCOPY --from=assets / /assets\n", b.Files[b.Options.Dockerfile])
+			},
+		},
+		{
+			name: "Name_of_a_stage",
+			contexts: `
+        build: docker-image://busybox:1.36`,
+			expectErr: "additional context build has the same name as a stage of the Dockerfile",
+		},
+		{
+			name: "Remote_context",
+			contexts: `
+        repo: https://github.com/example/repo.git`,
+			expectErr: "additional context repo of service app: https://github.com/example/repo.git isn't supported",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			project, err := tryLoadProject(t, `
+services:
+  app:
+    build:
+      context: ./app
+      additional_contexts:`+tt.contexts+`
+`, composeconvert.LoadComposeProjectOptions{WorkingDir: dir})
+			require.NoError(t, err)
+
+			e := fakeengine.New()
+			err = Up(t.Context(), e, project, UpOptions{Events: discard})
+			if tt.expectErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectErr)
+				return
+			}
+			require.NoError(t, err)
+			tt.assertFunc(t, e)
+		})
+	}
+}
+
+func TestAdditionalContexts(t *testing.T) {
+	project := &types.Project{WorkingDir: "/srv/project"}
+	cfg := &types.BuildConfig{AdditionalContexts: types.Mapping{
+		"shared": "../shared",
+		"abs":    "/opt/assets",
+		"base":   "docker-image://alpine:3.20",
+		"repo":   "https://github.com/example/repo.git",
+	}}
+	assert.Equal(t, map[string]string{
+		"shared": "/srv/shared",
+		"abs":    "/opt/assets",
+		"base":   "docker-image://alpine:3.20",
+		"repo":   "https://github.com/example/repo.git",
+	}, additionalContexts(project, cfg))
+}
+
+func TestDown_FakeEngine(t *testing.T) {
+	composeYML := `
+services:
+  app:
+    image: alpine:latest
+    depends_on: [db]
+    networks: [backend]
+  db:
+    image: alpine:latest
+    networks: [backend]
+    volumes:
+      - data:/data
+networks:
+  backend:
+volumes:
+  data:
+`
+	e := fakeengine.New()
+	project := loadProject(t, composeYML)
+	require.NoError(t, Up(t.Context(), e, project, UpOptions{Events: discard}))
+
+	zero := time.Duration(0)
+	require.NoError(t, Down(t.Context(), e, project, DownOptions{
+		Timeout:        &zero,
+		RemoveVolumes:  true,
+		RemoveNetworks: true,
+		Events:         discard,
+	}))
+
+	calls := e.Calls()
+	assert.Less(t, indexOf(calls, "ContainerRemove app"), indexOf(calls, "ContainerRemove db"), "dependents are removed first")
+	assert.NotEqual(t, -1, indexOf(calls, "VolumeRemove unit_data"))
+	assert.NotEqual(t, -1, indexOf(calls, "NetworkRemove unit_backend"))
+
+	list, err := Ps(t.Context(), e, project)
+	require.NoError(t, err)
+	assert.Empty(t, list)
+}
+
+func TestFakeEngine_Logs(t *testing.T) {
+	e := fakeengine.New()
+	e.SetBehavior("web", fakeengine.Behavior{Logs: "one\ntwo\nthree\n"})
+	project := loadProject(t, `
+services:
+  web:
+    image: alpine:latest
+`)
+	require.NoError(t, Up(t.Context(), e, project, UpOptions{Events: discard}))
+
+	var stdout, stderr strings.Builder
+	require.NoError(t, Logs(t.Context(), e, project, "web", &stdout, &stderr, LogsOptions{Tail: "2"}))
+	assert.Equal(t, "two\nthree\n", stdout.String())
+	assert.Empty(t, stderr.String())
+}
+
+func TestWaitForCondition_Events(t *testing.T) {
+	composeYML := `
+services:
+  app:
+    image: alpine:latest
+    depends_on:
+      db:
+        condition: service_healthy
+  db:
+    image: alpine:latest
+    healthcheck:
+      test: ["CMD", "true"]
+`
+	starting := make([]container.HealthStatus, 20)
+	for i := range starting {
+		starting[i] = container.Starting
+	}
+
+	t.Run("Health_changes_arrive_as_events", func(t *testing.T) {
+		e := fakeengine.New()
+		e.SetBehavior("db", fakeengine.Behavior{
+			Health:         append(starting, container.Healthy),
+			HealthInterval: 5 * time.Millisecond,
+		})
+		project := loadProject(t, composeYML)
+		require.NoError(t, Up(t.Context(), e, project, UpOptions{Events: discard}))
+
+		inspects := 0
+		for _, call := range e.Calls() {
+			if call == "ContainerInspect db" {
+				inspects++
+			}
+		}
+		assert.LessOrEqual(t, inspects, 3, "waiting on db shouldn't poll it")
+	})
+
+	t.Run("Falls_back_to_inspect_after_disconnect", func(t *testing.T) {
+		e := fakeengine.New()
+		e.SetBehavior("db", fakeengine.Behavior{
+			Health:         []container.HealthStatus{container.Starting, container.Healthy},
+			HealthInterval: 300 * time.Millisecond,
+		})
+		project := loadProject(t, composeYML)
+
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			e.DisconnectEvents(errors.New("connection reset by peer"))
+		}()
+
+		ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+		defer cancel()
+		require.NoError(t, Up(ctx, e, project, UpOptions{Events: discard}))
+		app, ok := e.Container("app")
+		require.True(t, ok)
+		assert.True(t, app.Running)
+	})
+}
@@ -0,0 +1,67 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"sort"
+
+	"github.com/compose-spec/compose-go/types"
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types/volume"
+)
+
+// ensureVolumes creates the declared top-level volumes used by at least one
+// service. Volumes that already exist are reused and external ones must
+// already exist. The volumes it creates are recorded in created.
+func ensureVolumes(ctx context.Context, cli Engine, events *emitter, stackConfig *types.Project, created *createdResources) error {
+	used := map[string]bool{}
+	for _, service := range stackConfig.Services {
+		for _, vol := range service.Volumes {
+			if vol.Type == types.VolumeTypeVolume && vol.Source != "" {
+				used[vol.Source] = true
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(used))
+	for key := range used {
+		if _, ok := stackConfig.Volumes[key]; ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		vol := stackConfig.Volumes[key]
+
+		_, err := cli.VolumeInspect(ctx, vol.Name)
+		if err == nil {
+			continue
+		}
+		if !cerrdefs.IsNotFound(err) {
+			return fmt.Errorf("inspect volume %s: %w", vol.Name, err)
+		}
+		if vol.External.External {
+			return fmt.Errorf("volume %s declared as external, but could not be found", vol.Name)
+		}
+
+		labels := map[string]string{}
+		maps.Copy(labels, vol.Labels)
+		maps.Copy(labels, projectLabels(stackConfig))
+		labels[VolumeLabel] = key
+
+		_, err = cli.VolumeCreate(ctx, volume.CreateOptions{
+			Name:       vol.Name,
+			Driver:     vol.Driver,
+			DriverOpts: vol.DriverOpts,
+			Labels:     labels,
+		})
+		if err != nil {
+			return fmt.Errorf("create volume %s: %w", vol.Name, err)
+		}
+		created.addVolume(vol.Name)
+		events.emit(Event{Type: EventVolumeCreated, Volume: vol.Name, Message: "Created volume " + vol.Name})
+	}
+	return nil
+}
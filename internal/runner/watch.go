@@ -0,0 +1,141 @@
+package runner
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/compose-spec/compose-go/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// reconnectDelay is how long the watcher waits before subscribing again after
+// the event stream dropped.
+const reconnectDelay = time.Second
+
+// containerWatcher subscribes once to the daemon's events for the project's
+// containers and fans them out to the goroutines waiting on dependencies, so
+// waiting doesn't need to poll.
+type containerWatcher struct {
+	cli     Engine
+	filters filters.Args
+
+	mu   sync.Mutex
+	subs map[string]map[*subscription]struct{}
+	// resync is closed and replaced whenever the stream drops or reconnects.
+	// Waiters fall back to inspecting the container then, as events may have
+	// been missed.
+	resync chan struct{}
+}
+
+// watchContainers starts watching the project's container events until ctx is
+// done.
+func watchContainers(ctx context.Context, cli Engine, stackConfig *types.Project) *containerWatcher {
+	w := &containerWatcher{
+		cli: cli,
+		filters: filters.NewArgs(
+			filters.Arg("type", string(events.ContainerEventType)),
+			filters.Arg("label", ProjectLabel+"="+stackConfig.Name),
+			filters.Arg("event", string(events.ActionStart)),
+			filters.Arg("event", string(events.ActionDie)),
+			filters.Arg("event", string(events.ActionHealthStatus)),
+		),
+		subs:   map[string]map[*subscription]struct{}{},
+		resync: make(chan struct{}),
+	}
+	go w.run(ctx, time.Now())
+	return w
+}
+
+func (w *containerWatcher) run(ctx context.Context, since time.Time) {
+	for {
+		// Asking for the events since the last one seen replays whatever
+		// happened while (re)connecting.
+		msgs, errs := w.cli.Events(ctx, events.ListOptions{
+			Since:   strconv.FormatFloat(float64(since.UnixNano())/1e9, 'f', 9, 64),
+			Filters: w.filters,
+		})
+		w.triggerResync()
+
+	stream:
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg := <-msgs:
+				if msg.TimeNano > 0 {
+					since = time.Unix(0, msg.TimeNano)
+				}
+				w.publish(msg)
+			case <-errs:
+				break stream
+			}
+		}
+
+		w.triggerResync()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(reconnectDelay):
+		}
+	}
+}
+
+func (w *containerWatcher) publish(msg events.Message) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for sub := range w.subs[msg.Actor.Attributes["name"]] {
+		select {
+		case sub.events <- msg:
+		default:
+			// A waiter that isn't keeping up re-inspects instead.
+			select {
+			case sub.missed <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+func (w *containerWatcher) triggerResync() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	close(w.resync)
+	w.resync = make(chan struct{})
+}
+
+// resyncSignal returns the channel that's closed on the next resync.
+func (w *containerWatcher) resyncSignal() <-chan struct{} {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.resync
+}
+
+// subscription receives the events of one container.
+type subscription struct {
+	events chan events.Message
+	// missed is signalled when an event had to be dropped.
+	missed chan struct{}
+}
+
+// subscribe returns a subscription to the named container's events, which
+// ends when cancel is called.
+func (w *containerWatcher) subscribe(name string) (*subscription, func()) {
+	sub := &subscription{
+		events: make(chan events.Message, 16),
+		missed: make(chan struct{}, 1),
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.subs[name] == nil {
+		w.subs[name] = map[*subscription]struct{}{}
+	}
+	w.subs[name][sub] = struct{}{}
+	return sub, func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		delete(w.subs[name], sub)
+	}
+}